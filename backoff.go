@@ -0,0 +1,103 @@
+package mqtt
+
+import (
+	"math"
+	"math/bits"
+	"math/rand"
+	"net/url"
+	"time"
+)
+
+// BackoffState is passed to a BackoffStrategy after a connection attempt fails
+type BackoffState struct {
+	Attempt   int           // number of consecutive failed attempts (1 after the first failure)
+	Previous  time.Duration // delay returned for the previous attempt (0 after the first failure)
+	Err       error         // error returned by the failed attempt
+	Broker    *url.URL      // the last broker that was tried
+	Reconnect bool          // true if reconnecting after the connection was lost (false for the initial Connect)
+}
+
+// BackoffStrategy determines how long the client waits before retrying a failed connection attempt.
+// It is used both when ConnectRetry is true and when automatically reconnecting. As the state
+// includes the error and broker it can also be used to log (or otherwise monitor) failures.
+// Delay may be called from multiple goroutines (when multiple clients share the strategy).
+type BackoffStrategy interface {
+	Delay(s BackoffState) time.Duration
+}
+
+// BackoffFunc is an adapter allowing an ordinary function to be used as a BackoffStrategy
+type BackoffFunc func(s BackoffState) time.Duration
+
+// Delay calls f(s)
+func (f BackoffFunc) Delay(s BackoffState) time.Duration {
+	return f(s)
+}
+
+// ConstantBackoff returns a BackoffStrategy that always waits for d
+func ConstantBackoff(d time.Duration) BackoffStrategy {
+	return BackoffFunc(func(BackoffState) time.Duration { return d })
+}
+
+// ExponentialJitterBackoff returns a BackoffStrategy implementing exponential backoff with "full jitter";
+// the delay is a random duration between 0 and base * 2^(attempt-1) (limited to max). This spreads
+// reconnection attempts from a large number of clients that lost their connections at the same time.
+func ExponentialJitterBackoff(base, max time.Duration) BackoffStrategy {
+	return BackoffFunc(func(s BackoffState) time.Duration {
+		limit := max
+		// base << shift does not exceed max (so cannot overflow) while shift < bits.Len64(max/base)
+		if base > 0 && s.Attempt > 0 && s.Attempt-1 < bits.Len64(uint64(max/base)) {
+			limit = base << uint(s.Attempt-1)
+		}
+		return randDuration(0, limit)
+	})
+}
+
+// DecorrelatedJitterBackoff returns a BackoffStrategy implementing "decorrelated jitter"; the delay is a
+// random duration between base and three times the previous delay (limited to max).
+func DecorrelatedJitterBackoff(base, max time.Duration) BackoffStrategy {
+	return BackoffFunc(func(s BackoffState) time.Duration {
+		prev := s.Previous
+		if prev < base {
+			prev = base
+		}
+		d := randDuration(base, 3*prev)
+		if d > max {
+			d = max
+		}
+		return d
+	})
+}
+
+// randDuration returns a random duration in the range [min, max]
+func randDuration(min, max time.Duration) time.Duration {
+	if max <= min {
+		return min
+	}
+	n := int64(max - min)
+	if n < math.MaxInt64 {
+		n++ // include max (unless that would overflow)
+	}
+	return min + time.Duration(rand.Int63n(n))
+}
+
+// defaultBackoff returns the strategy used when ClientOptions.BackoffStrategy is nil; this waits for
+// ConnectRetryInterval between connection attempts and doubles the delay (starting at 1 second, up to
+// MaxReconnectInterval) between reconnection attempts.
+func defaultBackoff(o *ClientOptions) BackoffStrategy {
+	return BackoffFunc(func(s BackoffState) time.Duration {
+		if !s.Reconnect {
+			return o.ConnectRetryInterval
+		}
+		if s.Previous == 0 {
+			return time.Second
+		}
+		d := s.Previous
+		if d < o.MaxReconnectInterval {
+			d *= 2
+		}
+		if d > o.MaxReconnectInterval {
+			d = o.MaxReconnectInterval
+		}
+		return d
+	})
+}
@@ -0,0 +1,302 @@
+package mqtt
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BrokerSelector determines the order in which the configured brokers are tried when connecting.
+// A selector may hold state (e.g. the last broker used) so each client should have its own instance.
+type BrokerSelector interface {
+	// Order returns the brokers in the order that they should be tried (the slice passed in must not be modified)
+	Order(brokers []*url.URL) []*url.URL
+	// Result is called after each attempt to connect to a broker; latency is the time taken to open the
+	// network connection and complete the MQTT handshake and err is nil if the connection was successful
+	Result(broker *url.URL, latency time.Duration, err error)
+}
+
+// OrderedSelector returns a BrokerSelector that tries brokers in the order they were added (the default)
+func OrderedSelector() BrokerSelector {
+	return orderedSelector{}
+}
+
+type orderedSelector struct{}
+
+func (orderedSelector) Order(brokers []*url.URL) []*url.URL         { return brokers }
+func (orderedSelector) Result(_ *url.URL, _ time.Duration, _ error) {}
+
+// RoundRobinSelector returns a BrokerSelector that starts each connection attempt with the broker following
+// the one that the previous attempt started with (spreading load across the brokers)
+func RoundRobinSelector() BrokerSelector {
+	return &roundRobinSelector{}
+}
+
+type roundRobinSelector struct {
+	mu   sync.Mutex
+	next int
+}
+
+func (r *roundRobinSelector) Order(brokers []*url.URL) []*url.URL {
+	if len(brokers) == 0 {
+		return brokers
+	}
+	r.mu.Lock()
+	start := r.next % len(brokers)
+	r.next = start + 1
+	r.mu.Unlock()
+	return append(append([]*url.URL{}, brokers[start:]...), brokers[:start]...)
+}
+
+func (r *roundRobinSelector) Result(_ *url.URL, _ time.Duration, _ error) {}
+
+// RandomSelector returns a BrokerSelector that tries brokers in a random order
+func RandomSelector() BrokerSelector {
+	return randomSelector{}
+}
+
+type randomSelector struct{}
+
+func (randomSelector) Order(brokers []*url.URL) []*url.URL {
+	o := append([]*url.URL{}, brokers...)
+	rand.Shuffle(len(o), func(i, j int) { o[i], o[j] = o[j], o[i] })
+	return o
+}
+
+func (randomSelector) Result(_ *url.URL, _ time.Duration, _ error) {}
+
+// StickySelector returns a BrokerSelector that tries the broker last successfully connected to first
+// and then the remaining brokers in the order they were added
+func StickySelector() BrokerSelector {
+	return &stickySelector{}
+}
+
+type stickySelector struct {
+	mu       sync.Mutex
+	lastGood string
+}
+
+func (s *stickySelector) Order(brokers []*url.URL) []*url.URL {
+	s.mu.Lock()
+	lastGood := s.lastGood
+	s.mu.Unlock()
+	for i, b := range brokers {
+		if b.String() == lastGood {
+			o := make([]*url.URL, 0, len(brokers))
+			o = append(o, b)
+			o = append(o, brokers[:i]...)
+			return append(o, brokers[i+1:]...)
+		}
+	}
+	return brokers
+}
+
+func (s *stickySelector) Result(broker *url.URL, _ time.Duration, err error) {
+	if err == nil {
+		s.mu.Lock()
+		s.lastGood = broker.String()
+		s.mu.Unlock()
+	}
+}
+
+// BrokerHealth contains the information held by a HealthSelector about a broker
+type BrokerHealth struct {
+	RecentFailures int           // failed attempts within the failure window
+	Latency        time.Duration // moving average of the time taken by successful connection attempts (0 if unknown)
+	LastError      error         // error from the most recent failed attempt
+	LastSuccess    time.Time     // time of the most recent successful attempt
+}
+
+// HealthSelector is a BrokerSelector that prefers brokers that have not failed recently and (optionally)
+// those that respond more quickly. Brokers of equal health are tried in the order provided by the base
+// selector. Use NewHealthSelector to create one.
+type HealthSelector struct {
+	base             BrokerSelector
+	failureWindow    time.Duration
+	latencyTolerance time.Duration
+
+	mu     sync.Mutex
+	health map[string]*brokerHealth
+}
+
+// brokerHealth holds the history of a single broker
+type brokerHealth struct {
+	failures    []time.Time // times of recent failures (oldest first)
+	latency     time.Duration
+	lastError   error
+	lastSuccess time.Time
+}
+
+// NewHealthSelector creates a HealthSelector. Brokers are ordered by the number of failures within
+// failureWindow and then, if latencyTolerance is not 0, by average latency (differences smaller than
+// latencyTolerance are ignored). base determines the order of equally healthy brokers (OrderedSelector
+// is used if nil).
+func NewHealthSelector(base BrokerSelector, failureWindow, latencyTolerance time.Duration) *HealthSelector {
+	if base == nil {
+		base = OrderedSelector()
+	}
+	return &HealthSelector{
+		base:             base,
+		failureWindow:    failureWindow,
+		latencyTolerance: latencyTolerance,
+		health:           make(map[string]*brokerHealth),
+	}
+}
+
+// Order implements BrokerSelector
+func (h *HealthSelector) Order(brokers []*url.URL) []*url.URL {
+	o := append([]*url.URL{}, h.base.Order(brokers)...)
+	now := time.Now()
+	type score struct{ failures, latency int64 }
+	scores := make(map[*url.URL]score, len(o))
+	h.mu.Lock()
+	for _, b := range o {
+		var s score
+		if bh, ok := h.health[b.String()]; ok {
+			bh.expire(now, h.failureWindow)
+			s.failures = int64(len(bh.failures))
+			if h.latencyTolerance > 0 {
+				s.latency = int64(bh.latency / h.latencyTolerance)
+			}
+		}
+		scores[b] = s
+	}
+	h.mu.Unlock()
+	sort.SliceStable(o, func(i, j int) bool {
+		si, sj := scores[o[i]], scores[o[j]]
+		if si.failures != sj.failures {
+			return si.failures < sj.failures
+		}
+		return si.latency < sj.latency
+	})
+	return o
+}
+
+// Result implements BrokerSelector
+func (h *HealthSelector) Result(broker *url.URL, latency time.Duration, err error) {
+	h.base.Result(broker, latency, err)
+	now := time.Now()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	bh, ok := h.health[broker.String()]
+	if !ok {
+		bh = &brokerHealth{}
+		h.health[broker.String()] = bh
+	}
+	if err != nil {
+		bh.failures = append(bh.failures, now)
+		bh.lastError = err
+		return
+	}
+	bh.lastSuccess = now
+	if bh.latency == 0 {
+		bh.latency = latency
+	} else {
+		bh.latency = (bh.latency*3 + latency) / 4
+	}
+}
+
+// Health returns the information held about broker
+func (h *HealthSelector) Health(broker *url.URL) BrokerHealth {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	bh, ok := h.health[broker.String()]
+	if !ok {
+		return BrokerHealth{}
+	}
+	bh.expire(time.Now(), h.failureWindow)
+	return BrokerHealth{
+		RecentFailures: len(bh.failures),
+		Latency:        bh.latency,
+		LastError:      bh.lastError,
+		LastSuccess:    bh.lastSuccess,
+	}
+}
+
+// expire removes failures that are outside of the window
+func (bh *brokerHealth) expire(now time.Time, window time.Duration) {
+	i := 0
+	for i < len(bh.failures) && now.Sub(bh.failures[i]) > window {
+		i++
+	}
+	bh.failures = bh.failures[i:]
+}
+
+// ServerResolver expands a server (as added with AddBroker) into the brokers that will be tried when
+// connecting. It is called before every connection attempt so the result may change over time.
+type ServerResolver interface {
+	Resolve(server *url.URL) ([]*url.URL, error)
+}
+
+// ErrNoBrokers is returned when the configured servers do not resolve to any brokers
+var ErrNoBrokers = errors.New("no brokers available")
+
+// SRVResolver is a ServerResolver that uses DNS SRV records to look up the brokers for servers with a
+// scheme ending in "+srv" (e.g. "tcp+srv://example.com" or "ssl+srv://example.com"); other servers are
+// returned unchanged. The "secure-mqtt" service is looked up for TLS schemes (ssl, tls, mqtts, tcps and
+// wss) and "mqtt" otherwise; so "tcp+srv://example.com" queries "_mqtt._tcp.example.com" and
+// resolves to "tcp://target:port" for each record (in the order returned by LookupSRV).
+type SRVResolver struct {
+	// LookupSRV performs the query; net.LookupSRV is used if nil (this can be replaced for testing)
+	LookupSRV func(service, proto, name string) (string, []*net.SRV, error)
+}
+
+// Resolve implements ServerResolver
+func (r *SRVResolver) Resolve(server *url.URL) ([]*url.URL, error) {
+	if !strings.HasSuffix(server.Scheme, "+srv") {
+		return []*url.URL{server}, nil
+	}
+	scheme := strings.TrimSuffix(server.Scheme, "+srv")
+	service := "mqtt"
+	if isTLSScheme(scheme) {
+		service = "secure-mqtt"
+	}
+	lookup := r.LookupSRV
+	if lookup == nil {
+		lookup = net.LookupSRV
+	}
+	_, addrs, err := lookup(service, "tcp", server.Hostname())
+	if err != nil {
+		return nil, err
+	}
+	brokers := make([]*url.URL, 0, len(addrs))
+	for _, a := range addrs {
+		b := *server
+		b.Scheme = scheme
+		b.Host = net.JoinHostPort(strings.TrimSuffix(a.Target, "."), strconv.Itoa(int(a.Port)))
+		brokers = append(brokers, &b)
+	}
+	return brokers, nil
+}
+
+// resolveServers expands the servers using the resolver (which may be nil); servers that fail to resolve are
+// skipped (the last error is returned if no brokers are found)
+func resolveServers(r ServerResolver, servers []*url.URL) ([]*url.URL, error) {
+	if r == nil {
+		return servers, nil
+	}
+	var brokers []*url.URL
+	var err error
+	for _, s := range servers {
+		resolved, rErr := r.Resolve(s)
+		if rErr != nil {
+			WARN.Println(CLI, "unable to resolve", s, rErr)
+			err = rErr
+			continue
+		}
+		brokers = append(brokers, resolved...)
+	}
+	if len(brokers) == 0 {
+		if err == nil {
+			err = ErrNoBrokers
+		}
+		return nil, err
+	}
+	return brokers, nil
+}
@@ -19,11 +19,12 @@ package mqtt
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"net"
 	"net/url"
-	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -75,7 +76,8 @@ type Client interface {
 	Disconnect(quiesce uint)
 	// Publish will publish a message with the specified QoS and content
 	// to the specified topic.
-	// Returns a token to track delivery of the message to the broker
+	// Returns a token to track delivery of the message to the broker (the token
+	// will be in error if topic is not a valid topic name; see ValidateTopicName)
 	Publish(topic string, qos byte, retained bool, payload interface{}) Token
 	// Subscribe starts a new subscription. Provide a MessageHandler to be executed when
 	// a message is published on the topic provided, or nil for the default handler.
@@ -117,6 +119,41 @@ type Client interface {
 	SetCustomCallback(callbackMethod func() (net.Conn, error))
 }
 
+// Reconfigurer is implemented by clients created by NewClient and allows the connection options to be
+// changed while the client is running. It is separate from the Client interface (so existing
+// implementations of Client are unaffected); use a type assertion to access it e.g.
+//
+//	if r, ok := c.(mqtt.Reconfigurer); ok {
+//		r.UpdateServers(servers)
+//		r.Reconnect(250)
+//	}
+type Reconfigurer interface {
+	// UpdateServers replaces the list of brokers; the new list is used from the next connection attempt
+	UpdateServers(servers []*url.URL)
+	// UpdateTLSConfig replaces the TLS configuration; the new configuration is used from the next
+	// connection attempt
+	UpdateTLSConfig(tlsc *tls.Config)
+	// UpdateCredentials replaces the username and password; the new credentials are used from the next
+	// connection attempt (a CredentialsProvider, if set, takes precedence)
+	UpdateCredentials(username, password string)
+	// Reconnect gracefully closes the current connection (waiting up to quiesce milliseconds for the
+	// DISCONNECT to be sent) and then reconnects, applying any updated options. Messages in flight are
+	// retained (as with an automatic reconnection). Does nothing if the client is not connected.
+	Reconnect(quiesce uint)
+}
+
+// BrokerReporter is implemented by clients created by NewClient. It is separate from the Client interface
+// (so existing implementations of Client are unaffected); use a type assertion to access it e.g.
+//
+//	if r, ok := c.(mqtt.BrokerReporter); ok {
+//		fmt.Println("connected to", r.ConnectedBroker())
+//	}
+type BrokerReporter interface {
+	// ConnectedBroker returns the broker that the client is, or was most recently, connected to
+	// (nil if a connection has never been established). This can be called from the OnConnect handler.
+	ConnectedBroker() *url.URL
+}
+
 // client implements the Client interface
 // clients are safe for concurrent use by multiple
 // goroutines
@@ -135,7 +172,7 @@ type client struct {
 	msgRouter *router              // routes topics to handlers
 	persist   Store
 	options   ClientOptions
-	optionsMu sync.Mutex // Protects the options in a few limited cases where needed for testing
+	optionsMu sync.Mutex // Protects the options that can be updated while the client is running (and OptionsReader copies)
 
 	conn   net.Conn   // the network connection, must only be set with connMu locked (only used when starting/stopping workers)
 	connMu sync.Mutex // mutex for the connection (again only used in two functions)
@@ -144,6 +181,11 @@ type client struct {
 	workers      sync.WaitGroup // used to wait for workers to complete (ping, keepalive, errwatch, resume)
 	commsStopped chan struct{}  // closed when the comms routines have stopped (kept running until after workers have closed to avoid deadlocks)
 
+	retryCancel chan struct{} // closed when Disconnect is called to interrupt connection retries
+	retryMu     sync.Mutex    // protects retryCancel
+
+	broker atomic.Value // *url.URL - the broker most recently connected to
+
 	InitialRC       byte                     //Save the Return Code for ZGrab2
 	useCallback     bool                     //Set to true to use custom callback method
 	connectCallback func() (net.Conn, error) //Callback for custom Connection
@@ -190,15 +232,26 @@ func (c *client) SetCustomCallback(callbackMethod func() (net.Conn, error)) {
 	c.connectCallback = callbackMethod
 }
 
+// ConnectedBroker returns the broker that the client is, or was most recently, connected to
+func (c *client) ConnectedBroker() *url.URL {
+	b, _ := c.broker.Load().(*url.URL)
+	return b
+}
+
 // AddRoute allows you to add a handler for messages on a specific topic
 // without making a subscription. For example having a different handler
-// for parts of a wildcard subscription
+// for parts of a wildcard subscription. The route is not added (and an
+// error logged) if topic is not a valid topic filter.
 //
 // If options.OrderMatters is true (the default) then callback must not block or
 // call functions within this package that may block (e.g. Publish) other than in
 // a new go routine.
 // callback must be safe for concurrent use by multiple goroutines.
 func (c *client) AddRoute(topic string, callback MessageHandler) {
+	if err := ValidateTopicFilter(topic); err != nil {
+		ERROR.Println(CLI, "route not added for invalid topic", topic, err)
+		return
+	}
 	if callback != nil {
 		c.msgRouter.addRoute(topic, callback)
 	}
@@ -275,30 +328,43 @@ func (c *client) Connect() Token {
 		return t
 	}
 
+	if c.options.WillEnabled {
+		if err := validateWill(c.options.WillTopic, c.options.WillQos); err != nil {
+			t.setError(err)
+			return t
+		}
+	}
+
 	c.persist.Open()
 	if c.options.ConnectRetry {
 		c.reserveStoredPublishIDs() // Reserve IDs to allow publish before connect complete
 	}
 	c.setConnected(connecting)
+	cancel := c.newRetryCancel()
 
 	go func() {
-		if len(c.options.Servers) == 0 {
+		c.optionsMu.Lock()
+		noServers := len(c.options.Servers) == 0
+		c.optionsMu.Unlock()
+		if noServers {
 			t.setError(fmt.Errorf("no servers defined to connect to"))
 			return
 		}
 
+		backoff := BackoffState{}
 	RETRYCONN:
 		var conn net.Conn
 		var rc byte
 		var err error
-		conn, rc, t.sessionPresent, err = c.attemptConnection()
+		conn, rc, t.sessionPresent, backoff.Broker, err = c.attemptConnection()
 		c.InitialRC = rc //Save the Return Code for ZGrab2
 		if err != nil {
 			if c.options.ConnectRetry {
-				DEBUG.Println(CLI, "Connect failed, sleeping for", int(c.options.ConnectRetryInterval.Seconds()), "seconds and will then retry")
-				time.Sleep(c.options.ConnectRetryInterval)
-
-				if atomic.LoadUint32(&c.status) == connecting {
+				backoff.Attempt++
+				backoff.Err = err
+				backoff.Previous = c.backoffDelay(backoff)
+				DEBUG.Println(CLI, "Connect failed, sleeping for", backoff.Previous, "and will then retry")
+				if c.waitForRetry(cancel, backoff.Previous) && atomic.LoadUint32(&c.status) == connecting {
 					goto RETRYCONN
 				}
 			}
@@ -309,8 +375,9 @@ func (c *client) Connect() Token {
 			t.setError(err)
 			return
 		}
+		t.tlsState = tlsConnectionState(conn)
 		inboundFromStore := make(chan packets.ControlPacket) // there may be some inbound comms packets in the store that are awaiting processing
-		if c.startCommsWorkers(conn, inboundFromStore) {
+		if c.startCommsWorkers(conn, backoff.Broker, inboundFromStore) {
 			// Take care of any messages in the store
 			if !c.options.CleanSession {
 				c.resume(c.options.ResumeSubs, inboundFromStore)
@@ -332,36 +399,33 @@ func (c *client) Connect() Token {
 func (c *client) reconnect() {
 	DEBUG.Println(CLI, "enter reconnect")
 	var (
-		sleep = 1 * time.Second
-		conn  net.Conn
+		backoff = BackoffState{Reconnect: true}
+		conn    net.Conn
+		err     error
+		cancel  = c.currentRetryCancel()
 	)
 
 	for {
 		if nil != c.options.OnReconnecting {
 			c.options.OnReconnecting(c, &c.options)
 		}
-		var err error
-		conn, _, _, err = c.attemptConnection()
+		conn, _, _, backoff.Broker, err = c.attemptConnection()
 		if err == nil {
 			break
 		}
-		DEBUG.Println(CLI, "Reconnect failed, sleeping for", int(sleep.Seconds()), "seconds:", err)
-		time.Sleep(sleep)
-		if sleep < c.options.MaxReconnectInterval {
-			sleep *= 2
-		}
-
-		if sleep > c.options.MaxReconnectInterval {
-			sleep = c.options.MaxReconnectInterval
-		}
+		backoff.Attempt++
+		backoff.Err = err
+		backoff.Previous = c.backoffDelay(backoff)
+		DEBUG.Println(CLI, "Reconnect failed, sleeping for", backoff.Previous, ":", err)
 		// Disconnect may have been called
-		if atomic.LoadUint32(&c.status) == disconnected {
+		if !c.waitForRetry(cancel, backoff.Previous) || atomic.LoadUint32(&c.status) == disconnected {
 			break
 		}
 	}
 
-	// Disconnect() must have been called while we were trying to reconnect.
-	if c.connectionStatus() == disconnected {
+	// Disconnect() must have been called while we were trying to reconnect (the loop only ends without a
+	// connection if the retry was cancelled).
+	if err != nil || conn == nil || c.connectionStatus() == disconnected {
 		if conn != nil {
 			conn.Close()
 		}
@@ -370,12 +434,72 @@ func (c *client) reconnect() {
 	}
 
 	inboundFromStore := make(chan packets.ControlPacket) // there may be some inbound comms packets in the store that are awaiting processing
-	if c.startCommsWorkers(conn, inboundFromStore) {
+	if c.startCommsWorkers(conn, backoff.Broker, inboundFromStore) {
 		c.resume(c.options.ResumeSubs, inboundFromStore)
 	}
 	close(inboundFromStore)
 }
 
+// backoffDelay returns the time to wait before the next connection attempt
+func (c *client) backoffDelay(s BackoffState) time.Duration {
+	if c.options.BackoffStrategy != nil {
+		return c.options.BackoffStrategy.Delay(s)
+	}
+	return defaultBackoff(&c.options).Delay(s)
+}
+
+// newRetryCancel returns the channel that will be closed when Disconnect is called (creating a new
+// one if the current channel has already been closed)
+func (c *client) newRetryCancel() chan struct{} {
+	c.retryMu.Lock()
+	defer c.retryMu.Unlock()
+	if c.retryCancel != nil {
+		select {
+		case <-c.retryCancel:
+		default:
+			return c.retryCancel
+		}
+	}
+	c.retryCancel = make(chan struct{})
+	return c.retryCancel
+}
+
+// currentRetryCancel returns the channel that will be closed when Disconnect is called
+func (c *client) currentRetryCancel() chan struct{} {
+	c.retryMu.Lock()
+	defer c.retryMu.Unlock()
+	if c.retryCancel == nil {
+		c.retryCancel = make(chan struct{})
+	}
+	return c.retryCancel
+}
+
+// cancelRetry interrupts any connection retries that are in progress
+func (c *client) cancelRetry() {
+	c.retryMu.Lock()
+	defer c.retryMu.Unlock()
+	if c.retryCancel != nil {
+		select {
+		case <-c.retryCancel:
+		default:
+			close(c.retryCancel)
+		}
+	}
+}
+
+// waitForRetry waits for d; returns false if cancel was closed (Disconnect was called) before then
+func (c *client) waitForRetry(cancel <-chan struct{}, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-cancel:
+		DEBUG.Println(CLI, "connection retry cancelled")
+		return false
+	}
+}
+
 // attemptConnection makes a single attempt to connect to each of the brokers
 // the protocol version to use is passed in (as c.options.ProtocolVersion)
 // Note: Does not set c.conn in order to minimise race conditions
@@ -383,41 +507,79 @@ func (c *client) reconnect() {
 // net.Conn - Connected network connection
 // byte - Return code (packets.Accepted indicates a successful connection).
 // bool - SessionPresent flag from the connect ack (only valid if packets.Accepted)
+// *url.URL - The last broker that was tried
 // err - Error (err != nil guarantees that conn has been set to active connection).
-func (c *client) attemptConnection() (net.Conn, byte, bool, error) {
-	protocolVersion := c.options.ProtocolVersion
+func (c *client) attemptConnection() (net.Conn, byte, bool, *url.URL, error) {
 	var (
 		sessionPresent bool
 		conn           net.Conn
 		err            error
 		rc             byte
+		lastBroker     *url.URL
+		start          time.Time
 	)
 
-	c.optionsMu.Lock() // Protect c.options.Servers so that servers can be added in test cases
-	brokers := c.options.Servers
+	c.optionsMu.Lock() // Protect the options that may be updated while the client is running
+	servers := c.options.Servers
+	options := c.options
 	c.optionsMu.Unlock()
-	for _, broker := range brokers {
-		cm := newConnectMsgFromOptions(&c.options, broker)
+	protocolVersion := options.ProtocolVersion
+	brokers, err := resolveServers(c.options.ServerResolver, servers)
+	if err != nil {
+		ERROR.Println(CLI, "unable to resolve brokers:", err)
+		return nil, packets.ErrNetworkError, false, nil, fmt.Errorf("%s : %w", packets.ConnErrors[packets.ErrNetworkError], err)
+	}
+	selector := c.options.BrokerSelector
+	if selector == nil {
+		selector = OrderedSelector()
+	}
+	// Disconnect cancels any connection attempt in progress
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func(retryCancel <-chan struct{}) {
+		select {
+		case <-retryCancel:
+			cancel()
+		case <-ctx.Done():
+		}
+	}(c.currentRetryCancel())
+	for _, broker := range selector.Order(brokers) {
+		lastBroker = broker
+		cm := newConnectMsgFromOptions(&options, broker)
 		DEBUG.Println(CLI, "about to write new connect msg")
 	CONN:
+		start = time.Now()
+		c.emitEvent(ConnectionEvent{Type: EventDialStarted, Time: start, Broker: broker})
 		// Start by opening the network connection (tcp, tls, ws) etc
 		//Due to compiling difficulties detour to custom method
-		conn, err = c.Detour(broker)
+		conn, err = c.dialBroker(ctx, broker)
 		if err != nil {
 			ERROR.Println(CLI, err.Error())
 			WARN.Println(CLI, "failed to connect to broker, trying next")
 			rc = packets.ErrNetworkError
+			selector.Result(broker, time.Since(start), err)
+			c.emitEvent(ConnectionEvent{Type: EventDialFailed, Broker: broker, Err: err, Duration: time.Since(start)})
 			continue
 		}
 		DEBUG.Println(CLI, "socket connected to broker")
+		if state := tlsConnectionState(conn); state != nil {
+			c.emitEvent(ConnectionEvent{Type: EventTLSHandshakeDone, Broker: broker, TLS: state, Duration: time.Since(start)})
+		}
 
 		// Now we send the perform the MQTT connection handshake
 		//Set Timeout for the Connect
 		conn.SetDeadline(time.Now().Add(c.options.WriteTimeout))
-		rc, sessionPresent, err = connectMQTT(conn, cm, protocolVersion)
+		rc, sessionPresent, err = connectMQTT(conn, cm, protocolVersion, c.getDecoder())
 		//Reset Deadline
 		conn.SetDeadline(time.Time{})
+		if rc == packets.ErrNetworkError {
+			c.emitEvent(ConnectionEvent{Type: EventHandshakeFailed, Broker: broker, Err: err, ProtocolVersion: protocolVersion, Duration: time.Since(start)})
+		} else {
+			c.emitEvent(ConnectionEvent{Type: EventConnackReceived, Broker: broker, ReturnCode: rc, SessionPresent: sessionPresent,
+				ProtocolVersion: protocolVersion, Duration: time.Since(start)})
+		}
 		if rc == packets.Accepted {
+			selector.Result(broker, time.Since(start), nil)
 			break // successfully connected
 		}
 
@@ -425,36 +587,123 @@ func (c *client) attemptConnection() (net.Conn, byte, bool, error) {
 		if conn != nil {
 			conn.Close()
 		}
-		if !c.options.protocolVersionExplicit && protocolVersion == 4 { // try falling back to 3.1?
+		if !options.protocolVersionExplicit && protocolVersion == 4 { // try falling back to 3.1?
 			DEBUG.Println(CLI, "Trying reconnect using MQTT 3.1 protocol")
 			protocolVersion = 3
+			c.emitEvent(ConnectionEvent{Type: EventProtocolDowngrade, Broker: broker, ProtocolVersion: protocolVersion})
 			goto CONN
 		}
-		if c.options.protocolVersionExplicit { // to maintain logging from previous version
+		if options.protocolVersionExplicit { // to maintain logging from previous version
 			ERROR.Println(CLI, "Connecting to", broker, "CONNACK was not CONN_ACCEPTED, but rather", packets.ConnackReturnCodes[rc])
 		}
+		if rc != packets.ErrNetworkError {
+			selector.Result(broker, time.Since(start), packets.ConnErrors[rc])
+		} else {
+			selector.Result(broker, time.Since(start), err)
+		}
 	}
 	// If the connection was successful we set member variable and lock in the protocol version for future connection attempts (and users)
 	if rc == packets.Accepted {
+		c.optionsMu.Lock()
 		c.options.ProtocolVersion = protocolVersion
 		c.options.protocolVersionExplicit = true
+		c.optionsMu.Unlock()
 	} else {
 		// Maintain same error format as used previously
 		if rc != packets.ErrNetworkError { // mqtt error
 			err = packets.ConnErrors[rc]
 		} else { // network error (if this occurred in ConnectMQTT then err will be nil)
-			err = fmt.Errorf("%s : %s", packets.ConnErrors[rc], err)
+			err = fmt.Errorf("%s : %w", packets.ConnErrors[rc], err)
 		}
 	}
-	return conn, rc, sessionPresent, err
+	return conn, rc, sessionPresent, lastBroker, err
 }
 
 //Detour enables the use of the custom Callback method
 func (c *client) Detour(broker *url.URL) (net.Conn, error) {
+	return c.dialBroker(context.Background(), broker)
+}
+
+// dialBroker opens the network connection to broker (the attempt is abandoned if ctx is cancelled)
+func (c *client) dialBroker(ctx context.Context, broker *url.URL) (net.Conn, error) {
 	if c.useCallback {
 		return c.connectCallback()
 	}
-	return openConnection(broker, c.options.TLSConfig, c.options.ConnectTimeout, c.options.HTTPHeaders, c.options.WebsocketOptions)
+	tlsc, err := c.tlsConfig(broker)
+	if err != nil {
+		return nil, err
+	}
+	var dialer ContextDialer
+	if c.options.Proxy != nil {
+		if dialer, err = NewProxyDialer(c.options.Proxy, c.options.ProxyTLSConfig, c.options.ConnectTimeout); err != nil {
+			return nil, err
+		}
+	}
+	if c.options.ProxyProtocol != nil {
+		if dialer == nil {
+			dialer = defaultDialer(c.options.ConnectTimeout)
+		}
+		dialer = &proxyProtocolDialer{header: c.options.ProxyProtocol, forward: dialer, environment: c.options.Proxy == nil}
+	}
+	return openConnection(ctx, withoutTLSOverrides(broker), tlsc, c.options.ConnectTimeout, c.options.HTTPHeaders, c.options.WebsocketOptions, dialer)
+}
+
+// UpdateServers replaces the list of brokers; the new list is used from the next connection attempt
+// (call Reconnect to apply it immediately)
+func (c *client) UpdateServers(servers []*url.URL) {
+	s := make([]*url.URL, len(servers))
+	copy(s, servers)
+	c.optionsMu.Lock()
+	c.options.Servers = s
+	c.optionsMu.Unlock()
+}
+
+// UpdateTLSConfig replaces the TLS configuration; the new configuration is used from the next connection
+// attempt (call Reconnect to apply it immediately)
+func (c *client) UpdateTLSConfig(tlsc *tls.Config) {
+	c.optionsMu.Lock()
+	c.options.TLSConfig = tlsc
+	c.optionsMu.Unlock()
+}
+
+// UpdateCredentials replaces the username and password; the new credentials are used from the next
+// connection attempt (call Reconnect to apply them immediately)
+func (c *client) UpdateCredentials(username, password string) {
+	c.optionsMu.Lock()
+	c.options.Username = username
+	c.options.Password = password
+	c.optionsMu.Unlock()
+}
+
+// Reconnect gracefully closes the current connection and then reconnects (regardless of AutoReconnect).
+// The store and message ids are retained so messages in flight will be resent (as per an automatic
+// reconnection) and OnConnectionLost is not called.
+func (c *client) Reconnect(quiesce uint) {
+	if atomic.LoadUint32(&c.status) != connected {
+		WARN.Println(CLI, "Reconnect() called but not connected")
+		return
+	}
+	DEBUG.Println(CLI, "reconnecting at users request")
+	c.setConnected(reconnecting)
+
+	dm := packets.NewControlPacket(packets.Disconnect).(*packets.DisconnectPacket)
+	dt := newToken(packets.Disconnect)
+	select {
+	case c.oboundP <- &PacketAndToken{p: dm, t: dt}:
+		dt.WaitTimeout(time.Duration(quiesce) * time.Millisecond)
+	case <-time.After(time.Duration(quiesce) * time.Millisecond):
+	}
+
+	done := c.stopCommsWorkers()
+	if done == nil {
+		return // connection already closed; internalConnLost completes the reconnection
+	}
+	go func() {
+		<-done
+		if c.connectionStatus() == reconnecting { // Disconnect may have been called
+			c.reconnect()
+		}
+	}()
 }
 
 // Disconnect will end the connection with the server, but not before waiting
@@ -462,9 +711,10 @@ func (c *client) Detour(broker *url.URL) (net.Conn, error) {
 // completed.
 func (c *client) Disconnect(quiesce uint) {
 	status := atomic.LoadUint32(&c.status)
+	c.setConnected(disconnected) // before cancelling any retry so that the retry loop sees the new status
+	c.cancelRetry()
 	if status == connected {
 		DEBUG.Println(CLI, "disconnecting")
-		c.setConnected(disconnected)
 
 		dm := packets.NewControlPacket(packets.Disconnect).(*packets.DisconnectPacket)
 		dt := newToken(packets.Disconnect)
@@ -478,7 +728,6 @@ func (c *client) Disconnect(quiesce uint) {
                }
 	} else {
 		WARN.Println(CLI, "Disconnect() called but not connected (disconnected/reconnecting)")
-		c.setConnected(disconnected)
 	}
 
 	c.disconnect()
@@ -515,7 +764,21 @@ func (c *client) internalConnLost(err error) {
 	// routines were actually running and are not being disconnected at users request
 	DEBUG.Println(CLI, "internalConnLost called")
 	stopDone := c.stopCommsWorkers()
+	if stopDone != nil && c.connectionStatus() == reconnecting {
+		// Reconnect was called and the connection closed (e.g. the broker closed it upon receiving the
+		// DISCONNECT) before Reconnect stopped the workers; this is not a connection loss so complete
+		// the reconnection on its behalf
+		DEBUG.Println(CLI, "internalConnLost during requested reconnect")
+		go func() {
+			<-stopDone
+			if c.connectionStatus() == reconnecting { // Disconnect may have been called
+				c.reconnect()
+			}
+		}()
+		return
+	}
 	if stopDone != nil { // stopDone will be nil if workers already in the process of stopping or stopped
+		c.emitEvent(ConnectionEvent{Type: EventConnectionLost, Broker: c.ConnectedBroker(), Err: err})
 		go func() {
 			DEBUG.Println(CLI, "internalConnLost waiting on workers")
 			<-stopDone
@@ -540,7 +803,7 @@ func (c *client) internalConnLost(err error) {
 // startCommsWorkers is called when the connection is up. It starts off all of the routines needed to process incoming and
 // outgoing messages.
 // Returns true if the comms workers were started (i.e. they were not already running)
-func (c *client) startCommsWorkers(conn net.Conn, inboundFromStore <-chan packets.ControlPacket) bool {
+func (c *client) startCommsWorkers(conn net.Conn, broker *url.URL, inboundFromStore <-chan packets.ControlPacket) bool {
 	DEBUG.Println(CLI, "startCommsWorkers called")
 	c.connMu.Lock()
 	defer c.connMu.Unlock()
@@ -550,6 +813,7 @@ func (c *client) startCommsWorkers(conn net.Conn, inboundFromStore <-chan packet
 		return false
 	}
 	c.conn = conn // Store the connection
+	c.broker.Store(broker)
 
 	c.stop = make(chan struct{})
 	if c.options.KeepAlive != 0 {
@@ -690,7 +954,14 @@ func (c *client) stopCommsWorkers() chan struct{} {
 func (c *client) Publish(topic string, qos byte, retained bool, payload interface{}) Token {
 	token := newToken(packets.Publish).(*PublishToken)
 	DEBUG.Println(CLI, "enter Publish")
+	if err := ValidateTopicName(topic); err != nil {
+		token.setError(err)
+		return token
+	}
 	switch {
+	case qos > 2:
+		token.setError(ErrInvalidQos)
+		return token
 	case !c.IsConnected():
 		token.setError(ErrNotConnected)
 		return token
@@ -778,14 +1049,6 @@ func (c *client) Subscribe(topic string, qos byte, callback MessageHandler) Toke
 	sub.Topics = append(sub.Topics, topic)
 	sub.Qoss = append(sub.Qoss, qos)
 
-	if strings.HasPrefix(topic, "$share/") {
-		topic = strings.Join(strings.Split(topic, "/")[2:], "/")
-	}
-
-	if strings.HasPrefix(topic, "$queue/") {
-		topic = strings.TrimPrefix(topic, "$queue/")
-	}
-
 	if callback != nil {
 		c.msgRouter.addRoute(topic, callback)
 	}
@@ -1007,6 +1270,7 @@ func (c *client) resume(subscription bool, ibound chan packets.ControlPacket) {
 		}
 	}
 	DEBUG.Println(STR, "exit resume")
+	c.emitEvent(ConnectionEvent{Type: EventResumeComplete, Broker: c.ConnectedBroker()})
 }
 
 // Unsubscribe will end the subscription from each of the topics provided.
@@ -1075,7 +1339,10 @@ func (c *client) Unsubscribe(topics ...string) Token {
 // OptionsReader returns a ClientOptionsReader which is a copy of the clientoptions
 // in use by the client.
 func (c *client) OptionsReader() ClientOptionsReader {
-	r := ClientOptionsReader{options: &c.options}
+	c.optionsMu.Lock()
+	o := c.options
+	c.optionsMu.Unlock()
+	r := ClientOptionsReader{options: &o}
 	return r
 }
 
@@ -1105,6 +1372,15 @@ func (c *client) getWriteTimeOut() time.Duration {
 	return c.options.WriteTimeout
 }
 
+// getDecoder returns the decoder used to read packets received from the broker
+func (c *client) getDecoder() *packets.Decoder {
+	return &packets.Decoder{
+		MaxPacketSize:   c.options.MaxIncomingPacketSize,
+		Strict:          c.options.StrictDecoding,
+		ZeroCopyPayload: c.options.ZeroCopyPayload,
+	}
+}
+
 // persistOutbound adds the packet to the outbound store
 func (c *client) persistOutbound(m packets.ControlPacket) {
 	persistOutbound(c.persist, m)
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"log"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+// broker is a minimal in-process MQTT v3.1.1 broker. It exists so that the benchmark can be run
+// without any external infrastructure (e.g. in CI); it supports QoS 0, 1 and 2, retained messages
+// and wildcard subscriptions but makes no attempt to persist sessions (every session is clean).
+type broker struct {
+	listener net.Listener
+
+	mu       sync.RWMutex
+	sessions map[*session]struct{}
+	retained map[string]*packets.PublishPacket
+}
+
+// session holds the state of a single client connection to the broker
+type session struct {
+	conn net.Conn
+	out  chan packets.ControlPacket // packets awaiting transmission (written by a dedicated goroutine)
+	done chan struct{}              // closed when the connection has been dropped
+
+	mu     sync.Mutex // protects subs and lastID
+	subs   map[string]byte
+	lastID uint16
+}
+
+// startBroker begins listening on addr (e.g. "127.0.0.1:0") and accepts connections in the background
+func startBroker(addr string) (*broker, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	b := &broker{
+		listener: l,
+		sessions: make(map[*session]struct{}),
+		retained: make(map[string]*packets.PublishPacket),
+	}
+	go b.accept()
+	return b, nil
+}
+
+// URL returns the address clients should use to connect to the broker
+func (b *broker) URL() string {
+	return "tcp://" + b.listener.Addr().String()
+}
+
+// Close stops the listener and drops all connected clients
+func (b *broker) Close() {
+	b.listener.Close()
+	b.mu.Lock()
+	for s := range b.sessions {
+		s.conn.Close()
+	}
+	b.mu.Unlock()
+}
+
+func (b *broker) accept() {
+	for {
+		conn, err := b.listener.Accept()
+		if err != nil {
+			return
+		}
+		go b.serve(conn)
+	}
+}
+
+// serve processes packets from a single client until the connection is closed
+func (b *broker) serve(conn net.Conn) {
+	s := &session{
+		conn: conn,
+		out:  make(chan packets.ControlPacket, 1024),
+		done: make(chan struct{}),
+		subs: make(map[string]byte),
+	}
+	defer func() {
+		b.mu.Lock()
+		delete(b.sessions, s)
+		b.mu.Unlock()
+		close(s.done)
+		conn.Close()
+	}()
+	go s.writer()
+
+	cp, err := packets.ReadPacket(conn)
+	if err != nil {
+		return
+	}
+	if _, ok := cp.(*packets.ConnectPacket); !ok {
+		log.Println("broker: first packet was not CONNECT")
+		return
+	}
+	ca := packets.NewControlPacket(packets.Connack).(*packets.ConnackPacket)
+	ca.ReturnCode = packets.Accepted
+	s.write(ca)
+	b.mu.Lock()
+	b.sessions[s] = struct{}{}
+	b.mu.Unlock()
+
+	for {
+		cp, err := packets.ReadPacket(conn)
+		if err != nil {
+			return
+		}
+		switch p := cp.(type) {
+		case *packets.PublishPacket:
+			switch p.Qos {
+			case 1:
+				pa := packets.NewControlPacket(packets.Puback).(*packets.PubackPacket)
+				pa.MessageID = p.MessageID
+				s.write(pa)
+			case 2:
+				pr := packets.NewControlPacket(packets.Pubrec).(*packets.PubrecPacket)
+				pr.MessageID = p.MessageID
+				s.write(pr)
+			}
+			b.publish(p)
+		case *packets.PubrelPacket:
+			pc := packets.NewControlPacket(packets.Pubcomp).(*packets.PubcompPacket)
+			pc.MessageID = p.MessageID
+			s.write(pc)
+		case *packets.PubrecPacket:
+			prel := packets.NewControlPacket(packets.Pubrel).(*packets.PubrelPacket)
+			prel.MessageID = p.MessageID
+			s.write(prel)
+		case *packets.SubscribePacket:
+			sa := packets.NewControlPacket(packets.Suback).(*packets.SubackPacket)
+			sa.MessageID = p.MessageID
+			s.mu.Lock()
+			for i, topic := range p.Topics {
+				s.subs[topic] = p.Qoss[i]
+				sa.ReturnCodes = append(sa.ReturnCodes, p.Qoss[i])
+			}
+			s.mu.Unlock()
+			s.write(sa)
+			b.sendRetained(s, p.Topics)
+		case *packets.UnsubscribePacket:
+			s.mu.Lock()
+			for _, topic := range p.Topics {
+				delete(s.subs, topic)
+			}
+			s.mu.Unlock()
+			ua := packets.NewControlPacket(packets.Unsuback).(*packets.UnsubackPacket)
+			ua.MessageID = p.MessageID
+			s.write(ua)
+		case *packets.PingreqPacket:
+			s.write(packets.NewControlPacket(packets.Pingresp))
+		case *packets.DisconnectPacket:
+			return
+		}
+	}
+}
+
+// publish stores the message (if retained) and forwards it to all matching subscribers
+func (b *broker) publish(p *packets.PublishPacket) {
+	b.mu.Lock()
+	if p.Retain {
+		if len(p.Payload) == 0 {
+			delete(b.retained, p.TopicName)
+		} else {
+			b.retained[p.TopicName] = p
+		}
+	}
+	targets := make([]*session, 0, len(b.sessions))
+	for s := range b.sessions {
+		targets = append(targets, s)
+	}
+	b.mu.Unlock()
+
+	for _, s := range targets {
+		if qos, ok := s.matches(p.TopicName); ok {
+			s.deliver(p, qos, false)
+		}
+	}
+}
+
+// sendRetained delivers any retained messages matching the newly subscribed filters
+func (b *broker) sendRetained(s *session, filters []string) {
+	b.mu.RLock()
+	var msgs []*packets.PublishPacket
+	for topic, p := range b.retained {
+		for _, f := range filters {
+			if topicMatches(f, topic) {
+				msgs = append(msgs, p)
+				break
+			}
+		}
+	}
+	b.mu.RUnlock()
+	for _, p := range msgs {
+		if qos, ok := s.matches(p.TopicName); ok {
+			s.deliver(p, qos, true)
+		}
+	}
+}
+
+// matches returns the highest QoS of the subscriptions matching topic
+func (s *session) matches(topic string) (byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var qos byte
+	found := false
+	for f, q := range s.subs {
+		if topicMatches(f, topic) {
+			if !found || q > qos {
+				qos = q
+			}
+			found = true
+		}
+	}
+	return qos, found
+}
+
+// deliver sends a copy of the message to the session at the lower of the publish and subscription QoS
+func (s *session) deliver(p *packets.PublishPacket, subQos byte, retained bool) {
+	out := p.Copy()
+	out.Qos = p.Qos
+	if subQos < out.Qos {
+		out.Qos = subQos
+	}
+	out.Retain = retained
+	if out.Qos > 0 {
+		s.mu.Lock()
+		s.lastID++
+		if s.lastID == 0 {
+			s.lastID = 1
+		}
+		out.MessageID = s.lastID
+		s.mu.Unlock()
+	}
+	s.write(out)
+}
+
+// write queues a packet for transmission; delivery to a slow client will block the sender (back pressure)
+func (s *session) write(cp packets.ControlPacket) {
+	select {
+	case s.out <- cp:
+	case <-s.done:
+	}
+}
+
+// writer transmits queued packets until the session ends
+func (s *session) writer() {
+	for {
+		select {
+		case cp := <-s.out:
+			if err := cp.Write(s.conn); err != nil {
+				s.conn.Close() // the reader will notice and end the session
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// topicMatches reports whether the topic name matches the subscription filter
+func topicMatches(filter, topic string) bool {
+	if strings.HasPrefix(topic, "$") && !strings.HasPrefix(filter, "$") {
+		return false
+	}
+	f := strings.Split(filter, "/")
+	t := strings.Split(topic, "/")
+	for i, level := range f {
+		if level == "#" {
+			return true
+		}
+		if i >= len(t) {
+			return false
+		}
+		if level != "+" && level != t[i] {
+			return false
+		}
+	}
+	return len(f) == len(t)
+}
@@ -0,0 +1,342 @@
+/*----------------------------------------------------------------------
+This command generates load against an MQTT broker in order to size
+brokers and to validate client changes. A number of publishing clients
+send timestamped messages at a fixed rate across a configurable number
+of topics while a set of subscribing clients receive them; end-to-end
+latency, throughput and token error rates are then written out as JSON.
+
+Passing -broker starts a minimal in-process broker (e.g.
+-broker 127.0.0.1:0) so that the benchmark can run without any external
+infrastructure; otherwise -server selects the broker to test.
+-----------------------------------------------------------------------*/
+
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	MQTT "github.com/eclipse/paho.mqtt.golang"
+)
+
+// headerLen is the number of bytes at the start of each payload used to carry the send time (unix nanoseconds)
+const headerLen = 8
+
+// config holds the benchmark settings (as provided on the command line)
+type config struct {
+	Server      string        `json:"server"`
+	Publishers  int           `json:"publishers"`
+	Subscribers int           `json:"subscribers"`
+	Rate        float64       `json:"rate"` // messages per second per publisher
+	PayloadSize int           `json:"payload_size"`
+	Qos         byte          `json:"qos"`
+	Topics      int           `json:"topics"` // number of distinct topics published to (fan-out)
+	TopicPrefix string        `json:"topic_prefix"`
+	Duration    time.Duration `json:"duration_ns"`
+	Drain       time.Duration `json:"drain_ns"`
+	Timeout     time.Duration `json:"timeout_ns"` // write timeout (also bounds the CONNECT handshake)
+}
+
+// validate checks the settings (other than Qos, which is checked when parsed)
+func (cfg config) validate() error {
+	if cfg.Topics < 1 || cfg.Publishers < 0 || cfg.Subscribers < 0 || !(cfg.Rate > 0) {
+		return errors.New("topics and rate must be positive and client counts must not be negative")
+	}
+	if cfg.interval() <= 0 {
+		return fmt.Errorf("rate %g is too high (at most one message per nanosecond can be sent)", cfg.Rate)
+	}
+	return nil
+}
+
+// interval returns the time between messages sent by each publisher
+func (cfg config) interval() time.Duration {
+	return time.Duration(float64(time.Second) / cfg.Rate)
+}
+
+// latencySummary holds end-to-end latency percentiles in milliseconds
+type latencySummary struct {
+	Min  float64 `json:"min"`
+	Mean float64 `json:"mean"`
+	P50  float64 `json:"p50"`
+	P90  float64 `json:"p90"`
+	P99  float64 `json:"p99"`
+	P999 float64 `json:"p999"`
+	Max  float64 `json:"max"`
+}
+
+// results is the output of a benchmark run
+type results struct {
+	Config             config         `json:"config"`
+	Start              time.Time      `json:"start"`
+	Elapsed            float64        `json:"elapsed_seconds"`
+	Published          int64          `json:"published"`
+	PublishErrors      int64          `json:"publish_errors"`
+	PublishErrorRate   float64        `json:"publish_error_rate"`
+	ConnectionErrors   int64          `json:"connection_errors"` // failed connects plus connections lost
+	Received           int64          `json:"received"`
+	Expected           int64          `json:"expected"`
+	PublishThroughput  float64        `json:"publish_throughput"` // messages per second (all publishers)
+	ReceiveThroughput  float64        `json:"receive_throughput"` // messages per second (all subscribers)
+	Latency            latencySummary `json:"latency_ms"`
+	MalformedPayloads  int64          `json:"malformed_payloads"`
+	ReceivedPerTopic   map[string]int `json:"received_per_topic,omitempty"`
+	SubscribeErrors    int64          `json:"subscribe_errors"`
+	OutstandingAtClose int64          `json:"outstanding_at_close"`
+}
+
+// collector accumulates the measurements taken by the subscribers
+type collector struct {
+	mu        sync.Mutex
+	latencies []time.Duration
+	perTopic  map[string]int
+	malformed int64
+}
+
+func (c *collector) handler(client MQTT.Client, msg MQTT.Message) {
+	now := time.Now()
+	p := msg.Payload()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(p) < headerLen {
+		c.malformed++
+		return
+	}
+	sent := time.Unix(0, int64(binary.BigEndian.Uint64(p)))
+	c.latencies = append(c.latencies, now.Sub(sent))
+	c.perTopic[msg.Topic()]++
+}
+
+func main() {
+	cfg := config{}
+	server := flag.String("server", "tcp://127.0.0.1:1883", "The full url of the MQTT server to connect to ex: tcp://127.0.0.1:1883")
+	embedded := flag.String("broker", "", "If set start the built-in broker listening on this address (e.g. 127.0.0.1:0) and benchmark against it")
+	flag.IntVar(&cfg.Publishers, "publishers", 1, "Number of publishing clients")
+	flag.IntVar(&cfg.Subscribers, "subscribers", 1, "Number of subscribing clients (each subscribes to every topic)")
+	flag.Float64Var(&cfg.Rate, "rate", 100, "Messages per second sent by each publisher")
+	flag.IntVar(&cfg.PayloadSize, "size", 64, "Payload size in bytes (minimum 8; the send timestamp is embedded in the payload)")
+	qos := flag.Int("qos", 0, "The QoS to publish and subscribe at")
+	flag.IntVar(&cfg.Topics, "topics", 1, "Number of distinct topics messages are spread across")
+	flag.StringVar(&cfg.TopicPrefix, "prefix", "bench", "Prefix for the topics used")
+	flag.DurationVar(&cfg.Duration, "duration", 10*time.Second, "How long to publish for")
+	flag.DurationVar(&cfg.Drain, "drain", 2*time.Second, "How long to wait for outstanding messages once publishing stops")
+	flag.DurationVar(&cfg.Timeout, "timeout", 10*time.Second, "Write timeout for each client (also limits the time allowed for the MQTT handshake)")
+	clientid := flag.String("clientid", "bench-"+strconv.Itoa(os.Getpid()), "Prefix for the client ids used")
+	out := flag.String("out", "-", "File to write JSON results to (- for stdout)")
+	flag.Parse()
+
+	if *qos < 0 || *qos > 2 {
+		log.Fatalf("invalid qos %d", *qos)
+	}
+	cfg.Qos = byte(*qos)
+	if cfg.PayloadSize < headerLen {
+		cfg.PayloadSize = headerLen
+	}
+	if err := cfg.validate(); err != nil {
+		log.Fatal(err)
+	}
+
+	cfg.Server = *server
+	if *embedded != "" {
+		b, err := startBroker(*embedded)
+		if err != nil {
+			log.Fatalf("unable to start built-in broker: %s", err)
+		}
+		defer b.Close()
+		cfg.Server = b.URL()
+	}
+
+	res := run(cfg, *clientid)
+
+	var w io.Writer = os.Stdout
+	if *out != "-" {
+		f, err := os.Create(*out)
+		if err != nil {
+			log.Fatalf("unable to create output file: %s", err)
+		}
+		defer f.Close()
+		w = f
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(res); err != nil {
+		log.Fatalf("unable to write results: %s", err)
+	}
+}
+
+// newClient connects a client to the server, returning nil (and incrementing errs) on failure
+func newClient(cfg config, id string, errs *int64) MQTT.Client {
+	opts := MQTT.NewClientOptions().AddBroker(cfg.Server).SetClientID(id).SetOrderMatters(false).SetAutoReconnect(false).
+		SetWriteTimeout(cfg.Timeout)
+	opts.SetConnectionLostHandler(func(MQTT.Client, error) {
+		atomic.AddInt64(errs, 1)
+	})
+	c := MQTT.NewClient(opts)
+	if t := c.Connect(); t.Wait() && t.Error() != nil {
+		log.Printf("client %s failed to connect: %s", id, t.Error())
+		atomic.AddInt64(errs, 1)
+		return nil
+	}
+	return c
+}
+
+// run carries out the benchmark described by cfg
+func run(cfg config, clientid string) *results {
+	res := &results{Config: cfg}
+	col := &collector{perTopic: make(map[string]int)}
+
+	var subscribers []MQTT.Client
+	for i := 0; i < cfg.Subscribers; i++ {
+		c := newClient(cfg, fmt.Sprintf("%s-sub-%d", clientid, i), &res.ConnectionErrors)
+		if c == nil {
+			continue
+		}
+		if t := c.Subscribe(cfg.TopicPrefix+"/#", cfg.Qos, col.handler); t.Wait() && t.Error() != nil {
+			log.Printf("subscribe failed: %s", t.Error())
+			res.SubscribeErrors++
+		}
+		subscribers = append(subscribers, c)
+	}
+
+	var publishers []MQTT.Client
+	for i := 0; i < cfg.Publishers; i++ {
+		if c := newClient(cfg, fmt.Sprintf("%s-pub-%d", clientid, i), &res.ConnectionErrors); c != nil {
+			publishers = append(publishers, c)
+		}
+	}
+
+	var (
+		published   int64
+		failed      int64
+		outstanding int64
+		wg          sync.WaitGroup
+		tokens      sync.WaitGroup
+	)
+	stop := make(chan struct{})
+	interval := cfg.interval()
+	res.Start = time.Now()
+	for i, c := range publishers {
+		wg.Add(1)
+		go func(i int, c MQTT.Client) {
+			defer wg.Done()
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for n := 0; ; n++ {
+				select {
+				case <-stop:
+					return
+				case <-ticker.C:
+				}
+				payload := make([]byte, cfg.PayloadSize)
+				binary.BigEndian.PutUint64(payload, uint64(time.Now().UnixNano()))
+				topic := fmt.Sprintf("%s/%d", cfg.TopicPrefix, (i+n)%cfg.Topics)
+				t := c.Publish(topic, cfg.Qos, false, payload)
+				atomic.AddInt64(&published, 1)
+				atomic.AddInt64(&outstanding, 1)
+				tokens.Add(1)
+				go func() {
+					defer tokens.Done()
+					<-t.Done()
+					atomic.AddInt64(&outstanding, -1)
+					if t.Error() != nil {
+						atomic.AddInt64(&failed, 1)
+					}
+				}()
+			}
+		}(i, c)
+	}
+
+	time.Sleep(cfg.Duration)
+	close(stop)
+	wg.Wait()
+	publishElapsed := time.Since(res.Start)
+
+	// Give the tokens and subscribers a chance to catch up
+	deadline := time.Now().Add(cfg.Drain)
+	waitDone := make(chan struct{})
+	go func() {
+		tokens.Wait()
+		close(waitDone)
+	}()
+	select {
+	case <-waitDone:
+	case <-time.After(time.Until(deadline)):
+	}
+	expected := atomic.LoadInt64(&published) * int64(len(subscribers))
+	for time.Now().Before(deadline) {
+		col.mu.Lock()
+		received := int64(len(col.latencies))
+		col.mu.Unlock()
+		if received >= expected {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	res.OutstandingAtClose = atomic.LoadInt64(&outstanding)
+	for _, c := range publishers {
+		c.Disconnect(250)
+	}
+	for _, c := range subscribers {
+		c.Disconnect(250)
+	}
+
+	col.mu.Lock()
+	defer col.mu.Unlock()
+	res.Elapsed = publishElapsed.Seconds()
+	res.Published = atomic.LoadInt64(&published)
+	res.PublishErrors = atomic.LoadInt64(&failed)
+	if res.Published > 0 {
+		res.PublishErrorRate = float64(res.PublishErrors) / float64(res.Published)
+	}
+	res.Received = int64(len(col.latencies))
+	res.Expected = expected
+	res.MalformedPayloads = col.malformed
+	res.ReceivedPerTopic = col.perTopic
+	if res.Elapsed > 0 {
+		res.PublishThroughput = float64(res.Published) / res.Elapsed
+		res.ReceiveThroughput = float64(res.Received) / res.Elapsed
+	}
+	res.Latency = summarise(col.latencies)
+	return res
+}
+
+// summarise calculates latency statistics (note: the slice passed in will be sorted)
+func summarise(l []time.Duration) latencySummary {
+	if len(l) == 0 {
+		return latencySummary{}
+	}
+	sort.Slice(l, func(i, j int) bool { return l[i] < l[j] })
+	ms := func(d time.Duration) float64 { return float64(d) / float64(time.Millisecond) }
+	pct := func(p float64) float64 {
+		i := int(math.Ceil(p*float64(len(l)))) - 1
+		if i < 0 {
+			i = 0
+		}
+		return ms(l[i])
+	}
+	var total time.Duration
+	for _, d := range l {
+		total += d
+	}
+	return latencySummary{
+		Min:  ms(l[0]),
+		Mean: ms(total / time.Duration(len(l))),
+		P50:  pct(0.50),
+		P90:  pct(0.90),
+		P99:  pct(0.99),
+		P999: pct(0.999),
+		Max:  ms(l[len(l)-1]),
+	}
+}
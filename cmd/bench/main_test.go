@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestConfigValidate(t *testing.T) {
+	valid := config{Publishers: 1, Subscribers: 1, Rate: 100, Topics: 1}
+	if err := valid.validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, rate := range []float64{0, -1, math.NaN(), 2e9, math.Inf(1)} {
+		cfg := valid
+		cfg.Rate = rate
+		if err := cfg.validate(); err == nil {
+			t.Errorf("expected rate %g to be rejected", rate)
+		}
+	}
+	for _, cfg := range []config{
+		{Publishers: 1, Subscribers: 1, Rate: 100, Topics: 0},
+		{Publishers: -1, Subscribers: 1, Rate: 100, Topics: 1},
+		{Publishers: 1, Subscribers: -1, Rate: 100, Topics: 1},
+	} {
+		if err := cfg.validate(); err == nil {
+			t.Errorf("expected %+v to be rejected", cfg)
+		}
+	}
+}
+
+func TestRunAgainstBuiltInBroker(t *testing.T) {
+	b, err := startBroker("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to start broker: %v", err)
+	}
+	defer b.Close()
+
+	cfg := config{
+		Server:      b.URL(),
+		Publishers:  2,
+		Subscribers: 2,
+		Rate:        100,
+		PayloadSize: 16,
+		Qos:         1,
+		Topics:      3,
+		TopicPrefix: "bench",
+		Duration:    200 * time.Millisecond,
+		Drain:       5 * time.Second,
+		Timeout:     5 * time.Second,
+	}
+	res := run(cfg, "test")
+
+	// Check the JSON output (as consumed by CI) rather than the struct
+	data, err := json.Marshal(res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out struct {
+		Config struct {
+			Server string `json:"server"`
+			Qos    int    `json:"qos"`
+		} `json:"config"`
+		Published        int64          `json:"published"`
+		PublishErrors    int64          `json:"publish_errors"`
+		ConnectionErrors int64          `json:"connection_errors"`
+		SubscribeErrors  int64          `json:"subscribe_errors"`
+		Received         int64          `json:"received"`
+		Expected         int64          `json:"expected"`
+		Malformed        int64          `json:"malformed_payloads"`
+		ReceivedPerTopic map[string]int `json:"received_per_topic"`
+		Throughput       float64        `json:"publish_throughput"`
+		Latency          struct {
+			Min float64 `json:"min"`
+			P50 float64 `json:"p50"`
+			Max float64 `json:"max"`
+		} `json:"latency_ms"`
+	}
+	if err = json.Unmarshal(data, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Config.Server != b.URL() || out.Config.Qos != 1 {
+		t.Errorf("unexpected config %+v", out.Config)
+	}
+	if out.Published == 0 || out.PublishErrors != 0 || out.ConnectionErrors != 0 || out.SubscribeErrors != 0 || out.Malformed != 0 {
+		t.Errorf("unexpected counts %s", data)
+	}
+	if out.Expected != 2*out.Published || out.Received != out.Expected {
+		t.Errorf("expected %d messages to be received by each subscriber, got %d of %d", out.Published, out.Received, out.Expected)
+	}
+	if len(out.ReceivedPerTopic) != 3 {
+		t.Errorf("expected messages on 3 topics, got %v", out.ReceivedPerTopic)
+	}
+	if out.Throughput <= 0 || out.Latency.Min < 0 || out.Latency.Min > out.Latency.P50 || out.Latency.P50 > out.Latency.Max {
+		t.Errorf("unexpected throughput or latency %s", data)
+	}
+}
@@ -0,0 +1,124 @@
+/*----------------------------------------------------------------------
+This sample records the messages received on a subscription to a file
+and replays recordings against a broker; it is intended to help in
+reproducing incidents. See the documentation of mqtt.Record for details
+of the file format.
+
+Record everything under sensors/ (until interrupted):
+  recorder -mode record -server tcp://prod:1883 -topic 'sensors/#' -file capture.jsonl
+
+Replay the capture to another broker at double the original speed:
+  recorder -mode replay -server tcp://test:1883 -file capture.jsonl -speed 2
+
+A speed of 0 replays the recording as fast as possible.
+-----------------------------------------------------------------------*/
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	MQTT "github.com/eclipse/paho.mqtt.golang"
+)
+
+func main() {
+	hostname, _ := os.Hostname()
+
+	mode := flag.String("mode", "record", "Either record or replay")
+	server := flag.String("server", "tcp://127.0.0.1:1883", "The full url of the MQTT server to connect to ex: tcp://127.0.0.1:1883")
+	topic := flag.String("topic", "#", "Topic filter to record")
+	qos := flag.Int("qos", 1, "The QoS to subscribe at when recording")
+	file := flag.String("file", "-", "Recording file (- for stdout when recording or stdin when replaying)")
+	raw := flag.Bool("raw", false, "Store the raw PUBLISH frame of each message when recording")
+	speed := flag.Float64("speed", 1, "Replay speed (1 = original timing, 2 = double speed, 0 = as fast as possible)")
+	clientid := flag.String("clientid", hostname+strconv.Itoa(time.Now().Second()), "A clientid for the connection")
+	username := flag.String("username", "", "A username to authenticate to the MQTT server")
+	password := flag.String("password", "", "Password to match username")
+	flag.Parse()
+
+	opts := MQTT.NewClientOptions().AddBroker(*server).SetClientID(*clientid).SetWriteTimeout(30 * time.Second)
+	if *username != "" {
+		opts.SetUsername(*username)
+		if *password != "" {
+			opts.SetPassword(*password)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sig
+		cancel()
+	}()
+
+	var err error
+	switch *mode {
+	case "record":
+		err = record(ctx, opts, *topic, byte(*qos), *file, *raw)
+	case "replay":
+		err = replay(ctx, opts, *file, *speed)
+	default:
+		err = fmt.Errorf("unknown mode %q", *mode)
+	}
+	if err != nil && err != context.Canceled {
+		log.Fatal(err)
+	}
+}
+
+// record writes every message received on topic to the file until ctx is cancelled
+func record(ctx context.Context, opts *MQTT.ClientOptions, topic string, qos byte, file string, raw bool) error {
+	out := os.Stdout
+	if file != "-" {
+		f, err := os.Create(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+	rw := MQTT.NewRecordWriter(out, raw)
+
+	// Subscribing in OnConnect means that the subscription will be reestablished following a reconnect
+	opts.SetOnConnectHandler(func(c MQTT.Client) {
+		if t := c.Subscribe(topic, qos, rw.Handler()); t.Wait() && t.Error() != nil {
+			log.Printf("unable to subscribe: %s", t.Error())
+		}
+	})
+	c := MQTT.NewClient(opts)
+	if t := c.Connect(); t.Wait() && t.Error() != nil {
+		return t.Error()
+	}
+	defer c.Disconnect(250)
+
+	<-ctx.Done()
+	return rw.Err()
+}
+
+// replay publishes the contents of the recording
+func replay(ctx context.Context, opts *MQTT.ClientOptions, file string, speed float64) error {
+	in := os.Stdin
+	if file != "-" {
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+
+	c := MQTT.NewClient(opts)
+	if t := c.Connect(); t.Wait() && t.Error() != nil {
+		return t.Error()
+	}
+	defer c.Disconnect(250)
+	return MQTT.ReplayToClient(ctx, MQTT.NewRecordReader(in), speed, c)
+}
@@ -0,0 +1,303 @@
+package mqtt
+
+import (
+	"bufio"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DedupKeyFunc returns the key used to identify duplicate messages. Two messages with the same key
+// received within the Deduplicator window are considered to be duplicates. An empty key means that
+// the message will never be treated as a duplicate.
+type DedupKeyFunc func(Message) string
+
+// DedupByMessageID keys messages on topic and message id (QoS 0 messages have no id so are never
+// considered duplicates). Note that message ids are reused once a message has been acknowledged so
+// the window used with this key should be short (e.g. a few seconds).
+func DedupByMessageID(m Message) string {
+	if m.Qos() == 0 {
+		return ""
+	}
+	return strconv.Itoa(int(m.MessageID())) + " " + m.Topic()
+}
+
+// DedupByPayloadHash keys messages on a hash of the topic and payload; identical messages published
+// to the same topic within the window will be suppressed regardless of how they were delivered.
+func DedupByPayloadHash(m Message) string {
+	h := sha256.New()
+	h.Write([]byte(m.Topic()))
+	h.Write([]byte{0})
+	h.Write(m.Payload())
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// DedupByPayloadPrefix returns a DedupKeyFunc that keys messages on the topic and the first n bytes
+// of the payload. This is intended for use where publishers include a unique message identifier
+// header at the start of the payload. Messages with payloads shorter than n bytes are not deduplicated.
+func DedupByPayloadPrefix(n int) DedupKeyFunc {
+	return func(m Message) string {
+		p := m.Payload()
+		if len(p) < n {
+			return ""
+		}
+		return m.Topic() + " " + string(p[:n])
+	}
+}
+
+// DedupEntry is a key that has been seen along with the time at which it was first seen
+type DedupEntry struct {
+	Key  string    `json:"key"`
+	Seen time.Time `json:"seen"`
+}
+
+// DedupPersistence allows the keys held by a Deduplicator to survive a restart
+type DedupPersistence interface {
+	// Load returns the persisted entries (which may include entries that have expired)
+	Load() ([]DedupEntry, error)
+	// Add persists a new entry
+	Add(DedupEntry) error
+	// Compact replaces the persisted entries with those provided (the current window)
+	Compact([]DedupEntry) error
+}
+
+// DedupStats contains the counters maintained by a Deduplicator
+type DedupStats struct {
+	Checked    uint64 // number of messages checked
+	Suppressed uint64 // number of messages suppressed as duplicates
+	Entries    int    // number of keys currently held
+}
+
+// Deduplicator suppresses duplicate inbound messages before they are passed to the message handlers.
+// It holds the keys of messages received within a window bounded by age and number of entries
+// (whichever limit is reached first). Use ClientOptions.SetDeduplicator to enable it.
+// Note: duplicate messages are still acknowledged to the broker.
+type Deduplicator struct {
+	key        DedupKeyFunc
+	maxAge     time.Duration
+	maxEntries int
+
+	mu           sync.Mutex
+	entries      map[string]*list.Element // value is a DedupEntry
+	order        *list.List               // entries in the order they were first seen
+	persist      DedupPersistence
+	sinceCompact int
+	stats        DedupStats
+}
+
+// NewDeduplicator creates a Deduplicator using the key function provided. Keys are forgotten once they
+// are older than maxAge or when more than maxEntries keys are held (a value of 0 means no limit; at
+// least one of these should be set).
+func NewDeduplicator(key DedupKeyFunc, maxAge time.Duration, maxEntries int) *Deduplicator {
+	return &Deduplicator{
+		key:        key,
+		maxAge:     maxAge,
+		maxEntries: maxEntries,
+		entries:    make(map[string]*list.Element),
+		order:      list.New(),
+	}
+}
+
+// SetPersistence loads any entries held by p and then persists all new entries to it. This should be
+// called before the Deduplicator is in use.
+func (d *Deduplicator) SetPersistence(p DedupPersistence) error {
+	loaded, err := p.Load()
+	if err != nil {
+		return err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, e := range loaded {
+		if _, ok := d.entries[e.Key]; !ok {
+			d.entries[e.Key] = d.order.PushBack(e)
+		}
+	}
+	d.expire(time.Now())
+	d.persist = p
+	return d.compact()
+}
+
+// Duplicate checks whether the message has been seen within the window (recording it if not)
+func (d *Deduplicator) Duplicate(m Message) bool {
+	key := d.key(m)
+	now := time.Now()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.stats.Checked++
+	d.expire(now)
+	if key == "" {
+		return false
+	}
+	if _, ok := d.entries[key]; ok {
+		d.stats.Suppressed++
+		return true
+	}
+	e := DedupEntry{Key: key, Seen: now}
+	d.entries[key] = d.order.PushBack(e)
+	d.expire(now)
+	if d.persist != nil {
+		if err := d.persist.Add(e); err != nil {
+			ERROR.Println(CLI, "unable to persist deduplication key:", err)
+		}
+		d.sinceCompact++
+		if d.sinceCompact > len(d.entries) && d.sinceCompact > 1000 {
+			if err := d.compact(); err != nil {
+				ERROR.Println(CLI, "unable to compact deduplication keys:", err)
+			}
+		}
+	}
+	return false
+}
+
+// expire removes entries that are outside of the window (mu must be held)
+func (d *Deduplicator) expire(now time.Time) {
+	for {
+		front := d.order.Front()
+		if front == nil {
+			return
+		}
+		e := front.Value.(DedupEntry)
+		if (d.maxAge > 0 && now.Sub(e.Seen) > d.maxAge) || (d.maxEntries > 0 && d.order.Len() > d.maxEntries) {
+			d.order.Remove(front)
+			delete(d.entries, e.Key)
+			continue
+		}
+		return
+	}
+}
+
+// compact rewrites the persisted entries so they match those held (mu must be held)
+func (d *Deduplicator) compact() error {
+	d.sinceCompact = 0
+	if d.persist == nil {
+		return nil
+	}
+	current := make([]DedupEntry, 0, d.order.Len())
+	for e := d.order.Front(); e != nil; e = e.Next() {
+		current = append(current, e.Value.(DedupEntry))
+	}
+	return d.persist.Compact(current)
+}
+
+// Stats returns the current counters
+func (d *Deduplicator) Stats() DedupStats {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	s := d.stats
+	s.Entries = len(d.entries)
+	return s
+}
+
+// FileDedupPersistence implements DedupPersistence using a file containing one JSON encoded
+// DedupEntry per line. New entries are appended to the file and it is rewritten when compacted.
+type FileDedupPersistence struct {
+	sync.Mutex
+	path string
+	f    *os.File
+}
+
+// NewFileDedupPersistence returns a FileDedupPersistence that stores entries in the file at path
+// (which will be created if it does not exist)
+func NewFileDedupPersistence(path string) *FileDedupPersistence {
+	return &FileDedupPersistence{path: path}
+}
+
+// Load returns the entries held in the file
+func (p *FileDedupPersistence) Load() ([]DedupEntry, error) {
+	p.Lock()
+	defer p.Unlock()
+	f, err := os.Open(p.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var entries []DedupEntry
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		var e DedupEntry
+		if err := json.Unmarshal(s.Bytes(), &e); err != nil {
+			// The last line may be incomplete if we crashed while writing it
+			WARN.Println(STR, "ignoring corrupt deduplication entry in", p.path)
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries, s.Err()
+}
+
+// Add appends an entry to the file
+func (p *FileDedupPersistence) Add(e DedupEntry) error {
+	p.Lock()
+	defer p.Unlock()
+	if p.f == nil {
+		f, err := os.OpenFile(p.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0660)
+		if err != nil {
+			return err
+		}
+		p.f = f
+	}
+	return writeDedupEntry(p.f, e)
+}
+
+// Compact replaces the contents of the file with the entries provided
+func (p *FileDedupPersistence) Compact(entries []DedupEntry) error {
+	p.Lock()
+	defer p.Unlock()
+	if p.f != nil {
+		p.f.Close()
+		p.f = nil
+	}
+	tmp := p.path + tmpExt
+	f, err := os.OpenFile(tmp, os.O_TRUNC|os.O_CREATE|os.O_WRONLY, 0660)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	for _, e := range entries {
+		if err = writeDedupEntry(w, e); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, p.path)
+}
+
+// Close closes the file (it will be reopened if further entries are added)
+func (p *FileDedupPersistence) Close() error {
+	p.Lock()
+	defer p.Unlock()
+	if p.f == nil {
+		return nil
+	}
+	err := p.f.Close()
+	p.f = nil
+	return err
+}
+
+func writeDedupEntry(w io.Writer, e DedupEntry) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("unable to encode deduplication entry: %s", err)
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
@@ -0,0 +1,90 @@
+package mqtt
+
+import (
+	"crypto/tls"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// ConnectionEventType identifies the type of a ConnectionEvent
+type ConnectionEventType int
+
+// The connection events; the ConnectionEvent fields set are shown for each type
+const (
+	EventDialStarted         ConnectionEventType = iota // about to open the network connection (Broker)
+	EventDialFailed                                     // unable to open the network connection (Broker, Err, Duration)
+	EventTLSHandshakeDone                               // TLS handshake complete (Broker, TLS, Duration)
+	EventConnackReceived                                // CONNACK received (Broker, ReturnCode, SessionPresent, ProtocolVersion, Duration)
+	EventHandshakeFailed                                // CONNECT could not be sent or no CONNACK was received (Broker, Err, ProtocolVersion, Duration)
+	EventProtocolDowngrade                              // CONNECT at 3.1.1 was refused so retrying with 3.1 (Broker, ProtocolVersion)
+	EventKeepaliveTimeout                               // PINGRESP was not received in time (Broker)
+	EventResumeComplete                                 // messages held in the store have been queued for resending (Broker)
+	EventConnectionLost                                 // the connection was lost (Broker, Err)
+	EventCertificateExpiring                            // the client certificate expires within TLSExpiryWarning (Broker, CertificateExpiry)
+)
+
+var connectionEventNames = map[ConnectionEventType]string{
+	EventDialStarted:         "dial started",
+	EventDialFailed:          "dial failed",
+	EventTLSHandshakeDone:    "TLS handshake done",
+	EventConnackReceived:     "CONNACK received",
+	EventHandshakeFailed:     "handshake failed",
+	EventProtocolDowngrade:   "protocol downgrade",
+	EventKeepaliveTimeout:    "keepalive timeout",
+	EventResumeComplete:      "resume complete",
+	EventConnectionLost:      "connection lost",
+	EventCertificateExpiring: "certificate expiring",
+}
+
+// String returns a description of the event type
+func (t ConnectionEventType) String() string {
+	if s, ok := connectionEventNames[t]; ok {
+		return s
+	}
+	return "unknown event " + strconv.Itoa(int(t))
+}
+
+// ConnectionEvent describes something that happened while establishing or maintaining a connection.
+// Only the fields relevant to the Type are set (see the ConnectionEventType constants).
+type ConnectionEvent struct {
+	Type              ConnectionEventType
+	Time              time.Time     // when the event occurred
+	Broker            *url.URL      // the broker being connected to
+	Err               error         // the error (for failure events)
+	Duration          time.Duration // time since the dial started
+	TLS               *tls.ConnectionState
+	ReturnCode        byte      // the CONNACK return code
+	SessionPresent    bool      // the CONNACK session present flag
+	ProtocolVersion   uint      // the protocol version used for the CONNECT
+	CertificateExpiry time.Time // when the client certificate expires
+}
+
+// ConnectionEventHandler is called for each ConnectionEvent. It is called synchronously from the goroutine
+// establishing or monitoring the connection so must not block or call functions within this package that
+// may block.
+type ConnectionEventHandler func(Client, ConnectionEvent)
+
+// ConnectionEventChannel returns a ConnectionEventHandler that passes events to the returned channel (which
+// has the capacity specified). Events are dropped if the channel is full.
+func ConnectionEventChannel(capacity int) (ConnectionEventHandler, <-chan ConnectionEvent) {
+	ch := make(chan ConnectionEvent, capacity)
+	return func(_ Client, e ConnectionEvent) {
+		select {
+		case ch <- e:
+		default:
+			WARN.Println(CLI, "connection event channel full; dropped", e.Type)
+		}
+	}, ch
+}
+
+// emitEvent passes e to the ConnectionEventHandler (if one has been set)
+func (c *client) emitEvent(e ConnectionEvent) {
+	if c.options.OnConnectionEvent == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	c.options.OnConnectionEvent(c, e)
+}
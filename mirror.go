@@ -0,0 +1,215 @@
+package mqtt
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+// MirrorUpdate describes a change to the state held by a TopicMirror
+type MirrorUpdate struct {
+	Topic   string
+	Message Message // the new value (the message with the empty payload if Deleted is true)
+	Deleted bool    // true if the topic was removed (a message with an empty payload was received)
+}
+
+// MirrorChangeHandler is called whenever the state held by a TopicMirror changes
+type MirrorChangeHandler func(MirrorUpdate)
+
+// TopicMirror subscribes to a topic filter and maintains the latest message received on each
+// matching topic (e.g. the current configuration held in retained messages under "config/#").
+// A message with an empty payload removes the topic (this is how retained messages are deleted).
+//
+// The mirror resubscribes whenever the client connects and is marked as stale while the connection
+// is down (the values held may be out of date). Note that, if a retained message is deleted while the
+// connection is down, the broker has no way of notifying us; so the entry will remain until a new
+// message is received on that topic.
+// A TopicMirror is safe for concurrent use by multiple goroutines.
+type TopicMirror struct {
+	filter string
+	qos    byte
+
+	mu       sync.RWMutex
+	client   Client // set when the subscription is first requested
+	state    map[string]Message
+	stale    bool
+	onChange []MirrorChangeHandler
+	watchers map[*mirrorWatcher]struct{}
+}
+
+// mirrorWatcher holds the details of a channel returned by Watch
+type mirrorWatcher struct {
+	filter []string
+	ch     chan MirrorUpdate
+}
+
+// NewTopicMirror returns a TopicMirror that will mirror messages matching filter. It adds handlers to
+// the options provided (calling any existing OnConnect, OnConnectionLost or OnReconnecting handlers)
+// so must be called before NewClient (and these handlers must not be replaced afterwards).
+// The subscription is made when the client connects.
+func NewTopicMirror(o *ClientOptions, filter string, qos byte) *TopicMirror {
+	m := &TopicMirror{
+		filter:   filter,
+		qos:      qos,
+		state:    make(map[string]Message),
+		stale:    true,
+		watchers: make(map[*mirrorWatcher]struct{}),
+	}
+
+	onConnect := o.OnConnect
+	o.OnConnect = func(c Client) {
+		m.subscribe(c)
+		if onConnect != nil {
+			onConnect(c)
+		}
+	}
+	onConnectionLost := o.OnConnectionLost
+	o.OnConnectionLost = func(c Client, err error) {
+		m.setStale(c)
+		if onConnectionLost != nil {
+			onConnectionLost(c, err)
+		}
+	}
+	onReconnecting := o.OnReconnecting
+	o.OnReconnecting = func(c Client, opts *ClientOptions) {
+		m.setStale(c)
+		if onReconnecting != nil {
+			onReconnecting(c, opts)
+		}
+	}
+	return m
+}
+
+// subscribe requests the subscription; the mirror is no longer stale once this is acknowledged
+func (m *TopicMirror) subscribe(c Client) {
+	m.mu.Lock()
+	m.client = c
+	m.mu.Unlock()
+	t := c.Subscribe(m.filter, m.qos, m.handler)
+	if t.Wait() && t.Error() != nil {
+		ERROR.Println(CLI, "topic mirror unable to subscribe to", m.filter, t.Error())
+		return
+	}
+	m.mu.Lock()
+	m.stale = false
+	m.mu.Unlock()
+}
+
+// setStale marks the mirror as stale unless the connection is up (connection lost handlers are called
+// asynchronously so may run after the connection has been reestablished)
+func (m *TopicMirror) setStale(c Client) {
+	if c.IsConnectionOpen() {
+		return
+	}
+	m.mu.Lock()
+	m.stale = true
+	m.mu.Unlock()
+}
+
+// handler processes incoming messages
+func (m *TopicMirror) handler(_ Client, msg Message) {
+	u := MirrorUpdate{Topic: msg.Topic(), Message: msg, Deleted: len(msg.Payload()) == 0}
+
+	m.mu.Lock()
+	if u.Deleted {
+		if _, ok := m.state[u.Topic]; !ok {
+			m.mu.Unlock()
+			return // nothing to delete so no change
+		}
+		delete(m.state, u.Topic)
+	} else {
+		m.state[u.Topic] = msg
+	}
+	callbacks := m.onChange
+	topic := strings.Split(u.Topic, "/")
+	for w := range m.watchers {
+		if match(w.filter, topic) {
+			select {
+			case w.ch <- u:
+			default:
+				WARN.Println(CLI, "topic mirror watcher channel full; update dropped for", u.Topic)
+			}
+		}
+	}
+	m.mu.Unlock()
+
+	for _, cb := range callbacks {
+		cb(u)
+	}
+}
+
+// Stale returns true if the mirror may not reflect the current state (because the connection is down
+// or the subscription has not yet been made)
+func (m *TopicMirror) Stale() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.stale
+}
+
+// Get returns the latest message received on topic
+func (m *TopicMirror) Get(topic string) (Message, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	msg, ok := m.state[topic]
+	return msg, ok
+}
+
+// Snapshot returns a copy of the current state (a map from topic to the latest message)
+func (m *TopicMirror) Snapshot() map[string]Message {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	s := make(map[string]Message, len(m.state))
+	for k, v := range m.state {
+		s[k] = v
+	}
+	return s
+}
+
+// OnChange adds a callback that will be called for every change. Callbacks are called from the
+// message handler so the restrictions on MessageHandler (e.g. not blocking when OrderMatters is
+// true) also apply to these.
+func (m *TopicMirror) OnChange(cb MirrorChangeHandler) {
+	m.mu.Lock()
+	m.onChange = append(m.onChange, cb)
+	m.mu.Unlock()
+}
+
+// Watch returns a channel that will receive changes to topics matching filter (which may contain
+// wildcards) along with a function that stops the watch (closing the channel). Updates are dropped
+// if the channel (which has the capacity specified) is full; Snapshot can be used to resynchronise.
+func (m *TopicMirror) Watch(filter string, capacity int) (<-chan MirrorUpdate, func()) {
+	w := &mirrorWatcher{filter: routeSplit(filter), ch: make(chan MirrorUpdate, capacity)}
+	m.mu.Lock()
+	m.watchers[w] = struct{}{}
+	m.mu.Unlock()
+	var once sync.Once
+	return w.ch, func() {
+		once.Do(func() {
+			m.mu.Lock()
+			if _, ok := m.watchers[w]; ok { // Close may have already stopped the watch
+				delete(m.watchers, w)
+				close(w.ch)
+			}
+			m.mu.Unlock()
+		})
+	}
+}
+
+// Close unsubscribes and stops all watches; the mirror should not be used after calling this
+func (m *TopicMirror) Close() Token {
+	m.mu.Lock()
+	for w := range m.watchers {
+		delete(m.watchers, w)
+		close(w.ch)
+	}
+	m.stale = true
+	c := m.client
+	m.mu.Unlock()
+	if c == nil { // never subscribed
+		t := newToken(packets.Unsubscribe)
+		t.flowComplete()
+		return t
+	}
+	return c.Unsubscribe(m.filter)
+}
@@ -35,11 +35,11 @@ const closedNetConnErrorText = "use of closed network connection" // error strin
 //
 // Note that, for backward compatibility, ConnectMQTT() suppresses the actual connection error (compare to connectMQTT()).
 func ConnectMQTT(conn net.Conn, cm *packets.ConnectPacket, protocolVersion uint) (byte, bool) {
-	rc, sessionPresent, _ := connectMQTT(conn, cm, protocolVersion)
+	rc, sessionPresent, _ := connectMQTT(conn, cm, protocolVersion, &packets.Decoder{})
 	return rc, sessionPresent
 }
 
-func connectMQTT(conn io.ReadWriter, cm *packets.ConnectPacket, protocolVersion uint) (byte, bool, error) {
+func connectMQTT(conn io.ReadWriter, cm *packets.ConnectPacket, protocolVersion uint, dec *packets.Decoder) (byte, bool, error) {
 	switch protocolVersion {
 	case 3:
 		DEBUG.Println(CLI, "Using MQTT 3.1 protocol")
@@ -64,7 +64,7 @@ func connectMQTT(conn io.ReadWriter, cm *packets.ConnectPacket, protocolVersion
 		return packets.ErrNetworkError, false, err
 	}
 
-	rc, sessionPresent, err := verifyCONNACK(conn)
+	rc, sessionPresent, err := verifyCONNACK(conn, dec)
 	return rc, sessionPresent, err
 }
 
@@ -72,10 +72,10 @@ func connectMQTT(conn io.ReadWriter, cm *packets.ConnectPacket, protocolVersion
 // when the connection is first started.
 // This prevents receiving incoming data while resume
 // is in progress if clean session is false.
-func verifyCONNACK(conn io.Reader) (byte, bool, error) {
+func verifyCONNACK(conn io.Reader, dec *packets.Decoder) (byte, bool, error) {
 	DEBUG.Println(NET, "connect started")
 
-	ca, err := packets.ReadPacket(conn)
+	ca, err := dec.ReadPacket(conn)
 	if err != nil {
 		ERROR.Println(NET, "connect got error", err)
 		return packets.ErrNetworkError, false, err
@@ -107,7 +107,7 @@ type inbound struct {
 // startIncoming initiates a goroutine that reads incoming messages off the wire and sends them to the channel (returned).
 // If there are any issues with the network connection then the returned channel will be closed and the goroutine will exit
 // (so closing the connection will terminate the goroutine)
-func startIncoming(conn io.Reader) <-chan inbound {
+func startIncoming(conn io.Reader, dec *packets.Decoder) <-chan inbound {
 	var err error
 	var cp packets.ControlPacket
 	ibound := make(chan inbound)
@@ -116,7 +116,7 @@ func startIncoming(conn io.Reader) <-chan inbound {
 
 	go func() {
 		for {
-			if cp, err = packets.ReadPacket(conn); err != nil {
+			if cp, err = dec.ReadPacket(conn); err != nil {
 				// We do not want to log the error if it is due to the network connection having been closed
 				// elsewhere (i.e. after sending DisconnectPacket). Detecting this situation is the subject of
 				// https://github.com/golang/go/issues/4373
@@ -152,7 +152,7 @@ func startIncomingComms(conn io.Reader,
 	c commsFns,
 	inboundFromStore <-chan packets.ControlPacket,
 ) <-chan incomingComms {
-	ibound := startIncoming(conn) // Start goroutine that reads from network connection
+	ibound := startIncoming(conn, c.getDecoder()) // Start goroutine that reads from network connection
 	output := make(chan incomingComms)
 
 	DEBUG.Println(NET, "startIncomingComms started")
@@ -357,6 +357,7 @@ type commsFns interface {
 	UpdateLastReceived()                     // Must be called whenever a packet is received
 	UpdateLastSent()                         // Must be called whenever a packet is successfully sent
 	getWriteTimeOut() time.Duration          // Return the writetimeout (or 0 if none)
+	getDecoder() *packets.Decoder            // Return the decoder used to read incoming packets
 	persistOutbound(m packets.ControlPacket) // add the packet to the outbound store
 	persistInbound(m packets.ControlPacket)  // add the packet to the inbound store
 	pingRespReceived()                       // Called when a ping response is received
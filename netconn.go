@@ -15,42 +15,40 @@
 package mqtt
 
 import (
+	"context"
 	"crypto/tls"
 	"errors"
 	"net"
 	"net/http"
 	"net/url"
-	"os"
 	"time"
-
-	"golang.org/x/net/proxy"
 )
 
 //
 // This just establishes the network connection; once established the type of connection should be irrelevant
 //
 
-// openConnection opens a network connection using the protocol indicated in the URL. Does not carry out any MQTT specific handshakes
-func openConnection(uri *url.URL, tlsc *tls.Config, timeout time.Duration, headers http.Header, websocketOptions *WebsocketOptions) (net.Conn, error) {
+// openConnection opens a network connection using the protocol indicated in the URL. Does not carry out any MQTT specific handshakes.
+// The connection is made using dialer (if nil the all_proxy environment variable is used to determine whether a proxy is needed);
+// timeout (if not 0) limits the overall time taken including any proxy negotiation and TLS handshake.
+func openConnection(ctx context.Context, uri *url.URL, tlsc *tls.Config, timeout time.Duration, headers http.Header, websocketOptions *WebsocketOptions, dialer ContextDialer) (net.Conn, error) {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
 	switch uri.Scheme {
 	case "ws":
-		conn, err := NewWebsocket(uri.String(), nil, timeout, headers, websocketOptions)
+		conn, err := newWebsocket(ctx, uri.String(), nil, timeout, headers, websocketOptions, dialer)
 		return conn, err
 	case "wss":
-		conn, err := NewWebsocket(uri.String(), tlsc, timeout, headers, websocketOptions)
+		conn, err := newWebsocket(ctx, uri.String(), tlsc, timeout, headers, websocketOptions, dialer)
 		return conn, err
 	case "mqtt", "tcp":
-		allProxy := os.Getenv("all_proxy")
-		if len(allProxy) == 0 {
-			conn, err := net.DialTimeout("tcp", uri.Host, timeout)
-			if err != nil {
-				return nil, err
-			}
-			return conn, nil
+		if dialer == nil {
+			dialer = defaultDialer(timeout)
 		}
-		proxyDialer := proxy.FromEnvironment()
-
-		conn, err := proxyDialer.Dial("tcp", uri.Host)
+		conn, err := dialer.DialContext(ctx, "tcp", uri.Host)
 		if err != nil {
 			return nil, err
 		}
@@ -62,30 +60,35 @@ func openConnection(uri *url.URL, tlsc *tls.Config, timeout time.Duration, heade
 		}
 		return conn, nil
 	case "ssl", "tls", "mqtts", "mqtt+ssl", "tcps":
-		allProxy := os.Getenv("all_proxy")
-		if len(allProxy) == 0 {
-			conn, err := tls.DialWithDialer(&net.Dialer{Timeout: timeout}, "tcp", uri.Host, tlsc)
-			if err != nil {
-				return nil, err
-			}
-			return conn, nil
+		if dialer == nil {
+			dialer = defaultDialer(timeout)
 		}
-		proxyDialer := proxy.FromEnvironment()
-
-		conn, err := proxyDialer.Dial("tcp", uri.Host)
+		conn, err := dialer.DialContext(ctx, "tcp", uri.Host)
 		if err != nil {
 			return nil, err
 		}
-
-		tlsConn := tls.Client(conn, tlsc)
-
-		err = tlsConn.Handshake()
+		tlsConn, err := tlsHandshake(ctx, conn, tlsc, uri.Hostname())
 		if err != nil {
-			conn.Close()
 			return nil, err
 		}
-
 		return tlsConn, nil
 	}
 	return nil, errors.New("unknown protocol")
 }
+
+// tlsConnectionState returns a snapshot of the TLS state of a connection returned by openConnection (nil
+// if the connection does not use TLS)
+func tlsConnectionState(conn net.Conn) *tls.ConnectionState {
+	switch c := conn.(type) {
+	case interface{ ConnectionState() tls.ConnectionState }:
+		state := c.ConnectionState()
+		return &state
+	case interface {
+		TLSConnectionState() (tls.ConnectionState, bool)
+	}:
+		if state, ok := c.TLSConnectionState(); ok {
+			return &state
+		}
+	}
+	return nil
+}
@@ -108,6 +108,63 @@ func (r *ClientOptionsReader) TLSConfig() *tls.Config {
 	return s
 }
 
+// TLSConfigProvider returns the function supplying TLS configurations (nil if TLSConfig is used)
+func (r *ClientOptionsReader) TLSConfigProvider() TLSConfigProvider {
+	s := r.options.TLSConfigProvider
+	return s
+}
+
+// Proxy returns the proxy used to connect to brokers (nil if not set)
+func (r *ClientOptionsReader) Proxy() *url.URL {
+	s := r.options.Proxy
+	return s
+}
+
+// ProxyTLSConfig returns the TLS configuration used when connecting to an https proxy
+func (r *ClientOptionsReader) ProxyTLSConfig() *tls.Config {
+	s := r.options.ProxyTLSConfig
+	return s
+}
+
+// MaxIncomingPacketSize returns the maximum size of packets accepted from the broker (0 if there is no limit)
+func (r *ClientOptionsReader) MaxIncomingPacketSize() int {
+	s := r.options.MaxIncomingPacketSize
+	return s
+}
+
+// StrictDecoding returns true if packets received from the broker are checked strictly
+func (r *ClientOptionsReader) StrictDecoding() bool {
+	s := r.options.StrictDecoding
+	return s
+}
+
+// ZeroCopyPayload returns true if message payloads reference the receive buffer rather than a copy
+func (r *ClientOptionsReader) ZeroCopyPayload() bool {
+	s := r.options.ZeroCopyPayload
+	return s
+}
+
+// ProxyProtocol returns the PROXY protocol header settings (nil if the header is not sent)
+func (r *ClientOptionsReader) ProxyProtocol() *ProxyProtocolHeader {
+	s := r.options.ProxyProtocol
+	return s
+}
+
+// TLSOverrides returns a copy of the per broker TLS overrides (keyed on host:port)
+func (r *ClientOptionsReader) TLSOverrides() map[string]TLSOverride {
+	s := make(map[string]TLSOverride, len(r.options.TLSOverrides))
+	for k, v := range r.options.TLSOverrides {
+		s[k] = v
+	}
+	return s
+}
+
+// TLSExpiryWarning returns how long before expiry a certificate expiry warning is generated
+func (r *ClientOptionsReader) TLSExpiryWarning() time.Duration {
+	s := r.options.TLSExpiryWarning
+	return s
+}
+
 func (r *ClientOptionsReader) KeepAlive() time.Duration {
 	s := time.Duration(r.options.KeepAlive * int64(time.Second))
 	return s
@@ -133,6 +190,25 @@ func (r *ClientOptionsReader) AutoReconnect() bool {
 	return s
 }
 
+// BackoffStrategy returns the strategy used to determine the delay between connection attempts
+// (nil if the default is in use)
+func (r *ClientOptionsReader) BackoffStrategy() BackoffStrategy {
+	s := r.options.BackoffStrategy
+	return s
+}
+
+// BrokerSelector returns the BrokerSelector in use (nil if brokers are tried in the order added)
+func (r *ClientOptionsReader) BrokerSelector() BrokerSelector {
+	s := r.options.BrokerSelector
+	return s
+}
+
+// ServerResolver returns the ServerResolver in use (nil if servers are not resolved)
+func (r *ClientOptionsReader) ServerResolver() ServerResolver {
+	s := r.options.ServerResolver
+	return s
+}
+
 // ConnectRetryInterval returns the delay between retries on the initial connection (if ConnectRetry true)
 func (r *ClientOptionsReader) ConnectRetryInterval() time.Duration {
 	s := r.options.ConnectRetryInterval
@@ -165,3 +241,9 @@ func (r *ClientOptionsReader) WebsocketOptions() *WebsocketOptions {
 	s := r.options.WebsocketOptions
 	return s
 }
+
+// Deduplicator returns the Deduplicator in use (nil if deduplication is disabled)
+func (r *ClientOptionsReader) Deduplicator() *Deduplicator {
+	s := r.options.Deduplicator
+	return s
+}
@@ -1,7 +1,6 @@
 package packets
 
 import (
-	"bytes"
 	"fmt"
 	"io"
 )
@@ -19,17 +18,11 @@ func (ca *ConnackPacket) String() string {
 }
 
 func (ca *ConnackPacket) Write(w io.Writer) error {
-	var body bytes.Buffer
-	var err error
-
-	body.WriteByte(boolToByte(ca.SessionPresent))
-	body.WriteByte(ca.ReturnCode)
 	ca.FixedHeader.RemainingLength = 2
-	packet := ca.FixedHeader.pack()
-	packet.Write(body.Bytes())
-	_, err = packet.WriteTo(w)
-
-	return err
+	bp := getBuffer(4)
+	b := ca.FixedHeader.appendHeader(*bp)
+	b = append(b, boolToByte(ca.SessionPresent), ca.ReturnCode)
+	return writeBuffer(w, bp, b)
 }
 
 // Unpack decodes the details of a ControlPacket after the fixed
@@ -45,6 +38,15 @@ func (ca *ConnackPacket) Unpack(b io.Reader) error {
 	return err
 }
 
+func (ca *ConnackPacket) unpackFrame(b []byte, _ bool) (int, error) {
+	if len(b) < 2 {
+		return len(b), io.ErrUnexpectedEOF
+	}
+	ca.SessionPresent = 1&b[0] > 0
+	ca.ReturnCode = b[1]
+	return 2, nil
+}
+
 // Details returns a Details struct containing the Qos and
 // MessageID of this ControlPacket
 func (ca *ConnackPacket) Details() Details {
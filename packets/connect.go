@@ -1,7 +1,6 @@
 package packets
 
 import (
-	"bytes"
 	"fmt"
 	"io"
 )
@@ -29,34 +28,46 @@ type ConnectPacket struct {
 }
 
 func (c *ConnectPacket) String() string {
-	return fmt.Sprintf("%s protocolversion: %d protocolname: %s cleansession: %t willflag: %t WillQos: %d WillRetain: %t Usernameflag: %t Passwordflag: %t keepalive: %d clientId: %s willtopic: %s willmessage: %s Username: %s Password: %s", c.FixedHeader, c.ProtocolVersion, c.ProtocolName, c.CleanSession, c.WillFlag, c.WillQos, c.WillRetain, c.UsernameFlag, c.PasswordFlag, c.Keepalive, c.ClientIdentifier, c.WillTopic, c.WillMessage, c.Username, c.Password)
+	return fmt.Sprintf("%s protocolversion: %d protocolname: %s cleansession: %t willflag: %t WillQos: %d WillRetain: %t Usernameflag: %t Passwordflag: %t keepalive: %d clientId: %s willtopic: %s willmessage: %s Username: %s Password: %s", c.FixedHeader, c.ProtocolVersion, c.ProtocolName, c.CleanSession, c.WillFlag, c.WillQos, c.WillRetain, c.UsernameFlag, c.PasswordFlag, c.Keepalive, c.ClientIdentifier, c.WillTopic, printable(c.WillMessage), c.Username, redactedPassword(c.Password))
 }
 
-func (c *ConnectPacket) Write(w io.Writer) error {
-	var body bytes.Buffer
-	var err error
+// redactedPassword returns a placeholder for the password so it does not appear in logs
+func redactedPassword(password []byte) string {
+	if len(password) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("<redacted, %d bytes>", len(password))
+}
 
-	body.Write(encodeString(c.ProtocolName))
-	body.WriteByte(c.ProtocolVersion)
-	body.WriteByte(boolToByte(c.CleanSession)<<1 | boolToByte(c.WillFlag)<<2 | c.WillQos<<3 | boolToByte(c.WillRetain)<<5 | boolToByte(c.PasswordFlag)<<6 | boolToByte(c.UsernameFlag)<<7)
-	body.Write(encodeUint16(c.Keepalive))
-	body.Write(encodeString(c.ClientIdentifier))
+func (c *ConnectPacket) Write(w io.Writer) error {
+	c.FixedHeader.RemainingLength = 2 + len(c.ProtocolName) + 4 + 2 + len(c.ClientIdentifier)
 	if c.WillFlag {
-		body.Write(encodeString(c.WillTopic))
-		body.Write(encodeBytes(c.WillMessage))
+		c.FixedHeader.RemainingLength += 2 + len(c.WillTopic) + 2 + len(c.WillMessage)
 	}
 	if c.UsernameFlag {
-		body.Write(encodeString(c.Username))
+		c.FixedHeader.RemainingLength += 2 + len(c.Username)
 	}
 	if c.PasswordFlag {
-		body.Write(encodeBytes(c.Password))
+		c.FixedHeader.RemainingLength += 2 + len(c.Password)
+	}
+	bp := getBuffer(5 + c.FixedHeader.RemainingLength)
+	b := c.FixedHeader.appendHeader(*bp)
+	b = appendString(b, c.ProtocolName)
+	b = append(b, c.ProtocolVersion)
+	b = append(b, boolToByte(c.CleanSession)<<1|boolToByte(c.WillFlag)<<2|c.WillQos<<3|boolToByte(c.WillRetain)<<5|boolToByte(c.PasswordFlag)<<6|boolToByte(c.UsernameFlag)<<7)
+	b = appendUint16(b, c.Keepalive)
+	b = appendString(b, c.ClientIdentifier)
+	if c.WillFlag {
+		b = appendString(b, c.WillTopic)
+		b = appendBytes(b, c.WillMessage)
 	}
-	c.FixedHeader.RemainingLength = body.Len()
-	packet := c.FixedHeader.pack()
-	packet.Write(body.Bytes())
-	_, err = packet.WriteTo(w)
-
-	return err
+	if c.UsernameFlag {
+		b = appendString(b, c.Username)
+	}
+	if c.PasswordFlag {
+		b = appendBytes(b, c.Password)
+	}
+	return writeBuffer(w, bp, b)
 }
 
 // Unpack decodes the details of a ControlPacket after the fixed
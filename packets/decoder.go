@@ -0,0 +1,344 @@
+package packets
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"unicode/utf8"
+)
+
+// Errors wrapped by DecodeError to identify the problem found
+var (
+	ErrPacketTooLarge  = errors.New("packet exceeds maximum size")
+	ErrMalformedLength = errors.New("malformed remaining length")
+	ErrReservedFlags   = errors.New("reserved flags set")
+	ErrInvalidQoS      = errors.New("invalid QoS")
+	ErrInvalidUTF8     = errors.New("malformed UTF-8 string")
+	ErrTrailingBytes   = errors.New("unexpected bytes after end of packet")
+	ErrUnsupportedType = errors.New("unsupported packet type")
+)
+
+// DecodeError is returned by Decoder.ReadPacket when a packet is malformed
+type DecodeError struct {
+	PacketType byte  // the packet type from the fixed header
+	Offset     int   // offset of the offending byte from the start of the packet
+	Err        error // the problem (one of the errors above or the error returned when unpacking the packet)
+}
+
+func (e *DecodeError) Error() string {
+	name, ok := PacketNames[e.PacketType]
+	if !ok {
+		name = fmt.Sprintf("type %d", e.PacketType)
+	}
+	return fmt.Sprintf("malformed %s packet at offset %d: %s", name, e.Offset, e.Err)
+}
+
+// Unwrap returns the underlying error
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// Decoder reads packets, applying limits suitable for use with untrusted peers. The zero value accepts any packet
+// that ReadPacket would accept. Each packet is read into a single (pooled) buffer and the fields decoded from it.
+// A Decoder must not be used concurrently.
+type Decoder struct {
+	// MaxPacketSize is the maximum size (including the fixed header) of packets accepted; 0 means no limit other
+	// than that imposed by the protocol. Larger packets are rejected before their body is read.
+	MaxPacketSize int
+
+	// Strict rejects packets that do not conform to the MQTT 3.1.1 specification: reserved flags set, QoS 3,
+	// strings that are not valid UTF-8 (or contain U+0000), data following the end of the packet and remaining
+	// lengths that are not minimally encoded
+	Strict bool
+
+	// ZeroCopyPayload causes the payload of PUBLISH packets to reference the buffer the packet was read into
+	// rather than being copied. This avoids copying large payloads, but the buffer cannot then be reused so
+	// each packet needs a new one.
+	ZeroCopyPayload bool
+
+	header [1]byte // avoids allocating when reading the fixed header
+}
+
+// frameUnpacker is implemented by packets that can be decoded directly from the received frame; unpackFrame
+// returns the number of bytes used. The frame is reused once unpackFrame returns unless zeroCopy is true.
+type frameUnpacker interface {
+	unpackFrame(b []byte, zeroCopy bool) (int, error)
+}
+
+// preallocateLimit is the largest buffer allocated before the data has been received (larger buffers grow as data
+// arrives so a peer cannot exhaust memory by claiming a large remaining length)
+const preallocateLimit = maxPooledBuffer
+
+// readBytes reads exactly n bytes from r (returning io.ErrUnexpectedEOF if fewer are available)
+func readBytes(r io.Reader, n int) ([]byte, error) {
+	if n <= preallocateLimit {
+		b := make([]byte, n)
+		_, err := io.ReadFull(r, b)
+		return b, err
+	}
+	var buf bytes.Buffer
+	read, err := io.CopyN(&buf, r, int64(n))
+	if err == io.EOF && read > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	return buf.Bytes(), err
+}
+
+// ReadPacket reads a packet from r. Malformed packets are reported with a *DecodeError; other errors are those
+// returned by r (io.ErrUnexpectedEOF if the stream ends part way through a packet).
+func (d *Decoder) ReadPacket(r io.Reader) (ControlPacket, error) {
+	var fh FixedHeader
+	b := d.header[:]
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	fh.MessageType = b[0] >> 4
+	fh.Dup = (b[0]>>3)&0x01 > 0
+	fh.Qos = (b[0] >> 1) & 0x03
+	fh.Retain = b[0]&0x01 > 0
+	decodeErr := func(offset int, err error) error {
+		return &DecodeError{PacketType: fh.MessageType, Offset: offset, Err: err}
+	}
+
+	headerLen := 1
+	var multiplier uint
+	for {
+		if _, err := io.ReadFull(r, b); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		fh.RemainingLength |= int(b[0]&127) << multiplier
+		headerLen++
+		if b[0]&128 == 0 {
+			break
+		}
+		if headerLen == 5 { // at most four bytes may be used
+			return nil, decodeErr(headerLen-1, ErrMalformedLength)
+		}
+		multiplier += 7
+	}
+	if d.Strict && headerLen > 2 && b[0] == 0 {
+		return nil, decodeErr(headerLen-1, ErrMalformedLength)
+	}
+
+	cp, err := NewControlPacketWithHeader(fh)
+	if err != nil {
+		return nil, decodeErr(0, ErrUnsupportedType)
+	}
+	if d.Strict {
+		if err = checkFlags(fh); err != nil {
+			return nil, decodeErr(0, err)
+		}
+	}
+	if d.MaxPacketSize > 0 && headerLen+fh.RemainingLength > d.MaxPacketSize {
+		return nil, decodeErr(1, fmt.Errorf("%w (%d bytes, limit %d)", ErrPacketTooLarge, headerLen+fh.RemainingLength, d.MaxPacketSize))
+	}
+
+	var body []byte
+	var bp *[]byte // the pooled buffer holding body (nil if body will be referenced by the packet)
+	if fh.RemainingLength > preallocateLimit || (d.ZeroCopyPayload && fh.MessageType == Publish) {
+		body, err = readBytes(r, fh.RemainingLength)
+	} else {
+		bp = getBuffer(fh.RemainingLength)
+		body = (*bp)[:fh.RemainingLength]
+		_, err = io.ReadFull(r, body)
+	}
+	if err != nil {
+		if bp != nil {
+			putBuffer(bp)
+		}
+		if err == io.EOF && fh.RemainingLength > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	err = d.unpack(cp, fh, headerLen, body, bp == nil)
+	if bp != nil {
+		putBuffer(bp)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return cp, nil
+}
+
+// unpack decodes body into cp (which must not reference body after returning unless zeroCopy is true)
+func (d *Decoder) unpack(cp ControlPacket, fh FixedHeader, headerLen int, body []byte, zeroCopy bool) error {
+	var n int
+	var err error
+	if fu, ok := cp.(frameUnpacker); ok {
+		n, err = fu.unpackFrame(body, zeroCopy)
+	} else {
+		br := bytes.NewReader(body)
+		err = cp.Unpack(br)
+		n = len(body) - br.Len()
+	}
+	if err != nil {
+		return &DecodeError{PacketType: fh.MessageType, Offset: headerLen + n, Err: err}
+	}
+	if d.Strict {
+		if n < len(body) {
+			return &DecodeError{PacketType: fh.MessageType, Offset: headerLen + n, Err: ErrTrailingBytes}
+		}
+		if offset, err := checkBody(fh, body); err != nil {
+			return &DecodeError{PacketType: fh.MessageType, Offset: headerLen + offset, Err: err}
+		}
+	}
+	return nil
+}
+
+// frameUint16 decodes the uint16 at b[pos:] returning the position following it
+func frameUint16(b []byte, pos int) (uint16, int, error) {
+	if pos+2 > len(b) {
+		return 0, len(b), io.ErrUnexpectedEOF
+	}
+	return binary.BigEndian.Uint16(b[pos:]), pos + 2, nil
+}
+
+// frameBytes returns the length prefixed field at b[pos:] and the position following it
+func frameBytes(b []byte, pos int) ([]byte, int, error) {
+	l, pos, err := frameUint16(b, pos)
+	if err != nil {
+		return nil, pos, err
+	}
+	if pos+int(l) > len(b) {
+		return nil, len(b), io.ErrUnexpectedEOF
+	}
+	return b[pos : pos+int(l)], pos + int(l), nil
+}
+
+// checkFlags checks the flags in the fixed header
+func checkFlags(fh FixedHeader) error {
+	switch fh.MessageType {
+	case Publish:
+		if fh.Qos == 3 {
+			return ErrInvalidQoS
+		}
+		if fh.Qos == 0 && fh.Dup {
+			return ErrReservedFlags
+		}
+		return nil
+	case Pubrel, Subscribe, Unsubscribe: // flags must be 0010
+		if fh.Dup || fh.Qos != 1 || fh.Retain {
+			return ErrReservedFlags
+		}
+		return nil
+	}
+	if fh.Dup || fh.Qos != 0 || fh.Retain {
+		return ErrReservedFlags
+	}
+	return nil
+}
+
+// checkBody performs the strict checks on the body of a packet that has been successfully unpacked, returning the
+// offset (within the body) of the first problem found
+func checkBody(fh FixedHeader, body []byte) (int, error) {
+	f := fieldReader{b: body}
+	switch fh.MessageType {
+	case Connect:
+		f.utf8String() // protocol name
+		f.pos++        // protocol level
+		flags := f.byte()
+		if flags&0x01 != 0 {
+			return f.pos - 1, ErrReservedFlags
+		}
+		if (flags>>3)&0x03 == 3 {
+			return f.pos - 1, ErrInvalidQoS
+		}
+		f.pos += 2 // keepalive
+		f.utf8String()
+		if flags&0x04 != 0 {
+			f.utf8String() // will topic
+			f.bytes()      // will message
+		}
+		if flags&0x80 != 0 {
+			f.utf8String()
+		}
+	case Connack:
+		if len(body) > 0 && body[0]&0xFE != 0 {
+			return 0, ErrReservedFlags
+		}
+	case Publish:
+		f.utf8String()
+	case Subscribe:
+		f.pos += 2 // message id
+		for f.err == nil && f.pos < len(body) {
+			f.utf8String()
+			if q := f.byte(); q > 2 {
+				if q&0xFC != 0 {
+					return f.pos - 1, ErrReservedFlags
+				}
+				return f.pos - 1, ErrInvalidQoS
+			}
+		}
+	case Suback:
+		for i, rc := range body[2:] {
+			if rc > 2 && rc != 0x80 {
+				return 2 + i, ErrInvalidQoS
+			}
+		}
+	case Unsubscribe:
+		f.pos += 2 // message id
+		for f.err == nil && f.pos < len(body) {
+			f.utf8String()
+		}
+	}
+	return f.pos, f.err
+}
+
+// fieldReader walks the fields of a packet body recording the offset of the first error found
+type fieldReader struct {
+	b   []byte
+	pos int
+	err error
+}
+
+func (f *fieldReader) byte() byte {
+	if f.err == nil && f.pos >= len(f.b) {
+		f.err = io.ErrUnexpectedEOF
+	}
+	if f.err != nil {
+		return 0
+	}
+	f.pos++
+	return f.b[f.pos-1]
+}
+
+func (f *fieldReader) bytes() []byte {
+	if f.err == nil && f.pos+2 > len(f.b) {
+		f.err = io.ErrUnexpectedEOF
+	}
+	if f.err != nil {
+		return nil
+	}
+	l := int(binary.BigEndian.Uint16(f.b[f.pos:]))
+	if f.pos+2+l > len(f.b) {
+		f.err = io.ErrUnexpectedEOF
+		return nil
+	}
+	f.pos += 2 + l
+	return f.b[f.pos-l : f.pos]
+}
+
+// utf8String checks that the next field is a valid MQTT UTF-8 string
+func (f *fieldReader) utf8String() {
+	s := f.bytes()
+	if f.err != nil {
+		return
+	}
+	start := f.pos - len(s)
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRune(s[i:])
+		if (r == utf8.RuneError && size == 1) || r == 0 {
+			f.pos = start + i
+			f.err = ErrInvalidUTF8
+			return
+		}
+		i += size
+	}
+}
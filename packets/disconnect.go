@@ -15,10 +15,9 @@ func (d *DisconnectPacket) String() string {
 }
 
 func (d *DisconnectPacket) Write(w io.Writer) error {
-	packet := d.FixedHeader.pack()
-	_, err := packet.WriteTo(w)
-
-	return err
+	bp := getBuffer(2)
+	b := d.FixedHeader.appendHeader(*bp)
+	return writeBuffer(w, bp, b)
 }
 
 // Unpack decodes the details of a ControlPacket after the fixed
@@ -27,6 +26,10 @@ func (d *DisconnectPacket) Unpack(b io.Reader) error {
 	return nil
 }
 
+func (d *DisconnectPacket) unpackFrame(_ []byte, _ bool) (int, error) {
+	return 0, nil
+}
+
 // Details returns a Details struct containing the Qos and
 // MessageID of this ControlPacket
 func (d *DisconnectPacket) Details() Details {
@@ -0,0 +1,345 @@
+package packets
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+)
+
+// FormatOptions controls how packets are formatted by ToJSON and PrettyPrint
+type FormatOptions struct {
+	ShowPassword bool // include the password from CONNECT packets (by default only its length is shown)
+	Hex          bool // encode binary fields in hex rather than base64 (JSON only)
+}
+
+// ToJSON encodes a packet as a JSON object. The fields present depend upon the packet type:
+//
+//	type             - packet type ("CONNECT", "CONNACK", "PUBLISH" etc.; see PacketNames)
+//	dup, qos, retain - flags from the fixed header
+//	remaining_length - remaining length from the fixed header
+//	message_id       - packet identifier (PUBLISH with QoS > 0, PUBACK, PUBREC, PUBREL, PUBCOMP, SUBSCRIBE,
+//	                   SUBACK, UNSUBSCRIBE and UNSUBACK)
+//	protocol_name    - CONNECT protocol name ("MQTT" or "MQIsdp")
+//	protocol_version - CONNECT protocol level
+//	clean_session    - CONNECT clean session flag
+//	reserved_bit     - CONNECT reserved flag (omitted if 0)
+//	keepalive        - CONNECT keepalive (seconds)
+//	client_id        - CONNECT client identifier
+//	will             - CONNECT will (omitted if the will flag is not set): {"topic", "qos", "retain", "message"}
+//	username         - CONNECT username (omitted if the username flag is not set)
+//	password         - CONNECT password (omitted if the password flag is not set)
+//	session_present  - CONNACK session present flag
+//	return_code      - CONNACK return code
+//	topic            - PUBLISH topic name
+//	payload          - PUBLISH payload
+//	subscriptions    - SUBSCRIBE topic filters and requested QoS: [{"topic", "qos"}]
+//	return_codes     - SUBACK return codes
+//	topics           - UNSUBSCRIBE topic filters
+//
+// Binary fields (payload, will message and password) are objects holding the data in base64 or hex encoding:
+// {"base64": "aGk="} or {"hex": "6869"}. Passwords are redacted unless o.ShowPassword is true; a redacted
+// password is encoded as {"redacted": true, "length": 8}.
+//
+// For example:
+//
+//	{"type":"PUBLISH","dup":false,"qos":1,"retain":false,"remaining_length":9,"message_id":1,"topic":"a/b","payload":{"base64":"aGk="}}
+func ToJSON(cp ControlPacket, o FormatOptions) ([]byte, error) {
+	j, err := toPacketJSON(cp, o)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(j)
+}
+
+// FromJSON decodes a packet encoded by ToJSON (redacted passwords are decoded as nil)
+func FromJSON(data []byte) (ControlPacket, error) {
+	var j packetJSON
+	if err := json.Unmarshal(data, &j); err != nil {
+		return nil, err
+	}
+	for t, name := range PacketNames {
+		if name == j.Type {
+			cp := NewControlPacket(t)
+			return cp, j.fill(cp)
+		}
+	}
+	return nil, fmt.Errorf("unknown packet type %q", j.Type)
+}
+
+// packetJSON holds the fields of every packet type (those not relevant to the type are omitted)
+type packetJSON struct {
+	Type            string             `json:"type"`
+	Dup             bool               `json:"dup"`
+	Qos             byte               `json:"qos"`
+	Retain          bool               `json:"retain"`
+	RemainingLength int                `json:"remaining_length"`
+	MessageID       *uint16            `json:"message_id,omitempty"`
+	ProtocolName    *string            `json:"protocol_name,omitempty"`
+	ProtocolVersion *byte              `json:"protocol_version,omitempty"`
+	CleanSession    *bool              `json:"clean_session,omitempty"`
+	ReservedBit     byte               `json:"reserved_bit,omitempty"`
+	Keepalive       *uint16            `json:"keepalive,omitempty"`
+	ClientID        *string            `json:"client_id,omitempty"`
+	Will            *willJSON          `json:"will,omitempty"`
+	Username        *string            `json:"username,omitempty"`
+	Password        *binaryJSON        `json:"password,omitempty"`
+	SessionPresent  *bool              `json:"session_present,omitempty"`
+	ReturnCode      *byte              `json:"return_code,omitempty"`
+	Topic           *string            `json:"topic,omitempty"`
+	Payload         *binaryJSON        `json:"payload,omitempty"`
+	Subscriptions   []subscriptionJSON `json:"subscriptions,omitempty"`
+	ReturnCodes     []int              `json:"return_codes,omitempty"`
+	Topics          []string           `json:"topics,omitempty"`
+}
+
+type willJSON struct {
+	Topic   string      `json:"topic"`
+	Qos     byte        `json:"qos"`
+	Retain  bool        `json:"retain"`
+	Message *binaryJSON `json:"message"`
+}
+
+type subscriptionJSON struct {
+	Topic string `json:"topic"`
+	Qos   byte   `json:"qos"`
+}
+
+// binaryJSON holds binary data in base64 or hex encoding (or the length of redacted data)
+type binaryJSON struct {
+	Base64   *string `json:"base64,omitempty"`
+	Hex      *string `json:"hex,omitempty"`
+	Redacted bool    `json:"redacted,omitempty"`
+	Length   int     `json:"length,omitempty"`
+}
+
+func newBinaryJSON(b []byte, o FormatOptions) *binaryJSON {
+	if o.Hex {
+		s := hex.EncodeToString(b)
+		return &binaryJSON{Hex: &s}
+	}
+	s := base64.StdEncoding.EncodeToString(b)
+	return &binaryJSON{Base64: &s}
+}
+
+func (b *binaryJSON) bytes() ([]byte, error) {
+	switch {
+	case b == nil || b.Redacted:
+		return nil, nil
+	case b.Base64 != nil:
+		return base64.StdEncoding.DecodeString(*b.Base64)
+	case b.Hex != nil:
+		return hex.DecodeString(*b.Hex)
+	}
+	return nil, fmt.Errorf("binary field has no base64 or hex value")
+}
+
+func toPacketJSON(cp ControlPacket, o FormatOptions) (*packetJSON, error) {
+	var j packetJSON
+	var fh *FixedHeader
+	id := cp.Details().MessageID
+	switch p := cp.(type) {
+	case *ConnectPacket:
+		fh = &p.FixedHeader
+		j.ProtocolName, j.ProtocolVersion, j.CleanSession = &p.ProtocolName, &p.ProtocolVersion, &p.CleanSession
+		j.ReservedBit, j.Keepalive, j.ClientID = p.ReservedBit, &p.Keepalive, &p.ClientIdentifier
+		if p.WillFlag {
+			j.Will = &willJSON{Topic: p.WillTopic, Qos: p.WillQos, Retain: p.WillRetain, Message: newBinaryJSON(p.WillMessage, o)}
+		}
+		if p.UsernameFlag {
+			j.Username = &p.Username
+		}
+		if p.PasswordFlag {
+			if o.ShowPassword {
+				j.Password = newBinaryJSON(p.Password, o)
+			} else {
+				j.Password = &binaryJSON{Redacted: true, Length: len(p.Password)}
+			}
+		}
+	case *ConnackPacket:
+		fh = &p.FixedHeader
+		j.SessionPresent, j.ReturnCode = &p.SessionPresent, &p.ReturnCode
+	case *PublishPacket:
+		fh = &p.FixedHeader
+		if p.Qos > 0 {
+			j.MessageID = &id
+		}
+		j.Topic, j.Payload = &p.TopicName, newBinaryJSON(p.Payload, o)
+	case *PubackPacket:
+		fh, j.MessageID = &p.FixedHeader, &id
+	case *PubrecPacket:
+		fh, j.MessageID = &p.FixedHeader, &id
+	case *PubrelPacket:
+		fh, j.MessageID = &p.FixedHeader, &id
+	case *PubcompPacket:
+		fh, j.MessageID = &p.FixedHeader, &id
+	case *SubscribePacket:
+		fh, j.MessageID = &p.FixedHeader, &id
+		for i, t := range p.Topics {
+			var qos byte
+			if i < len(p.Qoss) {
+				qos = p.Qoss[i]
+			}
+			j.Subscriptions = append(j.Subscriptions, subscriptionJSON{Topic: t, Qos: qos})
+		}
+	case *SubackPacket:
+		fh, j.MessageID = &p.FixedHeader, &id
+		for _, rc := range p.ReturnCodes {
+			j.ReturnCodes = append(j.ReturnCodes, int(rc))
+		}
+	case *UnsubscribePacket:
+		fh, j.MessageID = &p.FixedHeader, &id
+		j.Topics = p.Topics
+	case *UnsubackPacket:
+		fh, j.MessageID = &p.FixedHeader, &id
+	case *PingreqPacket:
+		fh = &p.FixedHeader
+	case *PingrespPacket:
+		fh = &p.FixedHeader
+	case *DisconnectPacket:
+		fh = &p.FixedHeader
+	default:
+		return nil, fmt.Errorf("unsupported packet %T", cp)
+	}
+	j.Type = PacketNames[fh.MessageType]
+	j.Dup, j.Qos, j.Retain, j.RemainingLength = fh.Dup, fh.Qos, fh.Retain, fh.RemainingLength
+	return &j, nil
+}
+
+// fill sets the fields of cp (which must be of the type named by j.Type) from j
+func (j *packetJSON) fill(cp ControlPacket) error {
+	var fh *FixedHeader
+	var typ byte
+	var id uint16
+	if j.MessageID != nil {
+		id = *j.MessageID
+	}
+	var err error
+	switch p := cp.(type) {
+	case *ConnectPacket:
+		fh, typ = &p.FixedHeader, Connect
+		p.ProtocolName, p.ProtocolVersion = stringValue(j.ProtocolName), byteValue(j.ProtocolVersion)
+		p.CleanSession, p.ReservedBit = j.CleanSession != nil && *j.CleanSession, j.ReservedBit
+		p.ClientIdentifier = stringValue(j.ClientID)
+		if j.Keepalive != nil {
+			p.Keepalive = *j.Keepalive
+		}
+		if p.WillFlag = j.Will != nil; p.WillFlag {
+			p.WillTopic, p.WillQos, p.WillRetain = j.Will.Topic, j.Will.Qos, j.Will.Retain
+			if p.WillMessage, err = j.Will.Message.bytes(); err != nil {
+				return err
+			}
+		}
+		p.UsernameFlag, p.Username = j.Username != nil, stringValue(j.Username)
+		if p.PasswordFlag = j.Password != nil; p.PasswordFlag {
+			if p.Password, err = j.Password.bytes(); err != nil {
+				return err
+			}
+		}
+	case *ConnackPacket:
+		fh, typ = &p.FixedHeader, Connack
+		p.SessionPresent, p.ReturnCode = j.SessionPresent != nil && *j.SessionPresent, byteValue(j.ReturnCode)
+	case *PublishPacket:
+		fh, typ = &p.FixedHeader, Publish
+		p.MessageID, p.TopicName = id, stringValue(j.Topic)
+		if p.Payload, err = j.Payload.bytes(); err != nil {
+			return err
+		}
+		if p.Payload == nil {
+			p.Payload = []byte{}
+		}
+	case *PubackPacket:
+		fh, typ, p.MessageID = &p.FixedHeader, Puback, id
+	case *PubrecPacket:
+		fh, typ, p.MessageID = &p.FixedHeader, Pubrec, id
+	case *PubrelPacket:
+		fh, typ, p.MessageID = &p.FixedHeader, Pubrel, id
+	case *PubcompPacket:
+		fh, typ, p.MessageID = &p.FixedHeader, Pubcomp, id
+	case *SubscribePacket:
+		fh, typ, p.MessageID = &p.FixedHeader, Subscribe, id
+		for _, s := range j.Subscriptions {
+			p.Topics = append(p.Topics, s.Topic)
+			p.Qoss = append(p.Qoss, s.Qos)
+		}
+	case *SubackPacket:
+		fh, typ, p.MessageID = &p.FixedHeader, Suback, id
+		p.ReturnCodes = make([]byte, len(j.ReturnCodes))
+		for i, rc := range j.ReturnCodes {
+			p.ReturnCodes[i] = byte(rc)
+		}
+	case *UnsubscribePacket:
+		fh, typ, p.MessageID = &p.FixedHeader, Unsubscribe, id
+		p.Topics = j.Topics
+	case *UnsubackPacket:
+		fh, typ, p.MessageID = &p.FixedHeader, Unsuback, id
+	case *PingreqPacket:
+		fh, typ = &p.FixedHeader, Pingreq
+	case *PingrespPacket:
+		fh, typ = &p.FixedHeader, Pingresp
+	case *DisconnectPacket:
+		fh, typ = &p.FixedHeader, Disconnect
+	default:
+		return fmt.Errorf("unsupported packet %T", cp)
+	}
+	if PacketNames[typ] != j.Type {
+		return fmt.Errorf("cannot decode %s packet into %s", j.Type, PacketNames[typ])
+	}
+	fh.MessageType, fh.Dup, fh.Qos, fh.Retain, fh.RemainingLength = typ, j.Dup, j.Qos, j.Retain, j.RemainingLength
+	return nil
+}
+
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+func byteValue(b *byte) byte {
+	if b == nil {
+		return 0
+	}
+	return *b
+}
+
+// unmarshalPacket decodes JSON produced by ToJSON into cp
+func unmarshalPacket(cp ControlPacket, data []byte) error {
+	var j packetJSON
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	return j.fill(cp)
+}
+
+// The packets implement json.Marshaler and json.Unmarshaler using ToJSON (with the default FormatOptions, so
+// passwords are redacted) and FromJSON
+
+func (c *ConnectPacket) MarshalJSON() ([]byte, error)        { return ToJSON(c, FormatOptions{}) }
+func (c *ConnectPacket) UnmarshalJSON(data []byte) error     { return unmarshalPacket(c, data) }
+func (ca *ConnackPacket) MarshalJSON() ([]byte, error)       { return ToJSON(ca, FormatOptions{}) }
+func (ca *ConnackPacket) UnmarshalJSON(data []byte) error    { return unmarshalPacket(ca, data) }
+func (p *PublishPacket) MarshalJSON() ([]byte, error)        { return ToJSON(p, FormatOptions{}) }
+func (p *PublishPacket) UnmarshalJSON(data []byte) error     { return unmarshalPacket(p, data) }
+func (pa *PubackPacket) MarshalJSON() ([]byte, error)        { return ToJSON(pa, FormatOptions{}) }
+func (pa *PubackPacket) UnmarshalJSON(data []byte) error     { return unmarshalPacket(pa, data) }
+func (pr *PubrecPacket) MarshalJSON() ([]byte, error)        { return ToJSON(pr, FormatOptions{}) }
+func (pr *PubrecPacket) UnmarshalJSON(data []byte) error     { return unmarshalPacket(pr, data) }
+func (pr *PubrelPacket) MarshalJSON() ([]byte, error)        { return ToJSON(pr, FormatOptions{}) }
+func (pr *PubrelPacket) UnmarshalJSON(data []byte) error     { return unmarshalPacket(pr, data) }
+func (pc *PubcompPacket) MarshalJSON() ([]byte, error)       { return ToJSON(pc, FormatOptions{}) }
+func (pc *PubcompPacket) UnmarshalJSON(data []byte) error    { return unmarshalPacket(pc, data) }
+func (s *SubscribePacket) MarshalJSON() ([]byte, error)      { return ToJSON(s, FormatOptions{}) }
+func (s *SubscribePacket) UnmarshalJSON(data []byte) error   { return unmarshalPacket(s, data) }
+func (sa *SubackPacket) MarshalJSON() ([]byte, error)        { return ToJSON(sa, FormatOptions{}) }
+func (sa *SubackPacket) UnmarshalJSON(data []byte) error     { return unmarshalPacket(sa, data) }
+func (u *UnsubscribePacket) MarshalJSON() ([]byte, error)    { return ToJSON(u, FormatOptions{}) }
+func (u *UnsubscribePacket) UnmarshalJSON(data []byte) error { return unmarshalPacket(u, data) }
+func (ua *UnsubackPacket) MarshalJSON() ([]byte, error)      { return ToJSON(ua, FormatOptions{}) }
+func (ua *UnsubackPacket) UnmarshalJSON(data []byte) error   { return unmarshalPacket(ua, data) }
+func (pr *PingreqPacket) MarshalJSON() ([]byte, error)       { return ToJSON(pr, FormatOptions{}) }
+func (pr *PingreqPacket) UnmarshalJSON(data []byte) error    { return unmarshalPacket(pr, data) }
+func (pr *PingrespPacket) MarshalJSON() ([]byte, error)      { return ToJSON(pr, FormatOptions{}) }
+func (pr *PingrespPacket) UnmarshalJSON(data []byte) error   { return unmarshalPacket(pr, data) }
+func (d *DisconnectPacket) MarshalJSON() ([]byte, error)     { return ToJSON(d, FormatOptions{}) }
+func (d *DisconnectPacket) UnmarshalJSON(data []byte) error  { return unmarshalPacket(d, data) }
@@ -1,11 +1,14 @@
 package packets
 
 import (
-	"bytes"
 	"encoding/binary"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
+	"sync"
+	"unicode"
+	"unicode/utf8"
 )
 
 // ControlPacket defines the interface for structs intended to hold
@@ -108,36 +111,10 @@ var ConnErrors = map[byte]error{
 // to read an MQTT packet from the stream. It returns a ControlPacket
 // representing the decoded MQTT packet and an error. One of these returns will
 // always be nil, a nil ControlPacket indicating an error occurred.
+// Use a Decoder to limit the size of packets accepted or to check packets strictly.
 func ReadPacket(r io.Reader) (ControlPacket, error) {
-	var fh FixedHeader
-	b := make([]byte, 1)
-
-	_, err := io.ReadFull(r, b)
-	if err != nil {
-		return nil, err
-	}
-
-	err = fh.unpack(b[0], r)
-	if err != nil {
-		return nil, err
-	}
-
-	cp, err := NewControlPacketWithHeader(fh)
-	if err != nil {
-		return nil, err
-	}
-
-	packetBytes := make([]byte, fh.RemainingLength)
-	n, err := io.ReadFull(r, packetBytes)
-	if err != nil {
-		return nil, err
-	}
-	if n != fh.RemainingLength {
-		return nil, errors.New("failed to read expected data")
-	}
-
-	err = cp.Unpack(bytes.NewBuffer(packetBytes))
-	return cp, err
+	var d Decoder
+	return d.ReadPacket(r)
 }
 
 // NewControlPacket is used to create a new ControlPacket of the type specified
@@ -246,11 +223,23 @@ func boolToByte(b bool) byte {
 	}
 }
 
-func (fh *FixedHeader) pack() bytes.Buffer {
-	var header bytes.Buffer
-	header.WriteByte(fh.MessageType<<4 | boolToByte(fh.Dup)<<3 | fh.Qos<<1 | boolToByte(fh.Retain))
-	header.Write(encodeLength(fh.RemainingLength))
-	return header
+// appendHeader appends the encoded fixed header to b
+func (fh *FixedHeader) appendHeader(b []byte) []byte {
+	b = append(b, fh.MessageType<<4|boolToByte(fh.Dup)<<3|fh.Qos<<1|boolToByte(fh.Retain))
+	return appendLength(b, fh.RemainingLength)
+}
+
+// printable returns b as a string if it is valid UTF-8 containing only printable characters and in hex otherwise
+func printable(b []byte) string {
+	if !utf8.Valid(b) {
+		return "0x" + hex.EncodeToString(b)
+	}
+	for _, r := range string(b) {
+		if !unicode.IsPrint(r) && !unicode.IsSpace(r) {
+			return "0x" + hex.EncodeToString(b)
+		}
+	}
+	return string(b)
 }
 
 func (fh *FixedHeader) unpack(typeAndFlags byte, r io.Reader) error {
@@ -266,7 +255,7 @@ func (fh *FixedHeader) unpack(typeAndFlags byte, r io.Reader) error {
 
 func decodeByte(b io.Reader) (byte, error) {
 	num := make([]byte, 1)
-	_, err := b.Read(num)
+	_, err := io.ReadFull(b, num)
 	if err != nil {
 		return 0, err
 	}
@@ -276,19 +265,28 @@ func decodeByte(b io.Reader) (byte, error) {
 
 func decodeUint16(b io.Reader) (uint16, error) {
 	num := make([]byte, 2)
-	_, err := b.Read(num)
+	_, err := io.ReadFull(b, num)
 	if err != nil {
 		return 0, err
 	}
 	return binary.BigEndian.Uint16(num), nil
 }
 
+func appendUint16(b []byte, num uint16) []byte {
+	return append(b, byte(num>>8), byte(num))
+}
+
 func encodeUint16(num uint16) []byte {
 	bytesResult := make([]byte, 2)
 	binary.BigEndian.PutUint16(bytesResult, num)
 	return bytesResult
 }
 
+func appendString(b []byte, field string) []byte {
+	b = appendUint16(b, uint16(len(field)))
+	return append(b, field...)
+}
+
 func encodeString(field string) []byte {
 	return encodeBytes([]byte(field))
 }
@@ -305,7 +303,7 @@ func decodeBytes(b io.Reader) ([]byte, error) {
 	}
 
 	field := make([]byte, fieldLength)
-	_, err = b.Read(field)
+	_, err = io.ReadFull(b, field)
 	if err != nil {
 		return nil, err
 	}
@@ -313,6 +311,11 @@ func decodeBytes(b io.Reader) ([]byte, error) {
 	return field, nil
 }
 
+func appendBytes(b []byte, field []byte) []byte {
+	b = appendUint16(b, uint16(len(field)))
+	return append(b, field...)
+}
+
 func encodeBytes(field []byte) []byte {
 	fieldLength := make([]byte, 2)
 	binary.BigEndian.PutUint16(fieldLength, uint16(len(field)))
@@ -320,19 +323,52 @@ func encodeBytes(field []byte) []byte {
 }
 
 func encodeLength(length int) []byte {
-	var encLength []byte
+	return appendLength(nil, length)
+}
+
+func appendLength(b []byte, length int) []byte {
 	for {
 		digit := byte(length % 128)
 		length /= 128
 		if length > 0 {
 			digit |= 0x80
 		}
-		encLength = append(encLength, digit)
+		b = append(b, digit)
 		if length == 0 {
-			break
+			return b
 		}
 	}
-	return encLength
+}
+
+// bufferPool holds the buffers used to encode and receive packets
+var bufferPool = sync.Pool{New: func() interface{} { return new([]byte) }}
+
+// maxPooledBuffer is the largest buffer returned to the pool (so that one large packet does not pin memory)
+const maxPooledBuffer = 64 * 1024
+
+// getBuffer returns an empty buffer from the pool with a capacity of at least size
+func getBuffer(size int) *[]byte {
+	bp := bufferPool.Get().(*[]byte)
+	if cap(*bp) < size {
+		*bp = make([]byte, 0, size)
+	}
+	*bp = (*bp)[:0]
+	return bp
+}
+
+// putBuffer returns a buffer obtained from getBuffer to the pool
+func putBuffer(bp *[]byte) {
+	if cap(*bp) <= maxPooledBuffer {
+		bufferPool.Put(bp)
+	}
+}
+
+// writeBuffer writes b (the contents of the pooled buffer bp) to w with a single call and releases the buffer
+func writeBuffer(w io.Writer, bp *[]byte, b []byte) error {
+	_, err := w.Write(b)
+	*bp = b
+	putBuffer(bp)
+	return err
 }
 
 func decodeLength(r io.Reader) (int, error) {
@@ -2,6 +2,14 @@ package packets
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"reflect"
+	"runtime"
+	"strings"
 	"testing"
 )
 
@@ -235,3 +243,401 @@ func TestEncoding(t *testing.T) {
 		}
 	}
 }
+
+func TestDecoder(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   []byte
+		strict bool
+		err    error // nil if the packet should be accepted
+		offset int
+	}{
+		{"valid publish", []byte{0x32, 0x07, 0x00, 0x01, 'a', 0x00, 0x01, 'h', 'i'}, true, nil, 0},
+		{"five byte length", []byte{0x30, 0x80, 0x80, 0x80, 0x80, 0x01}, false, ErrMalformedLength, 4},
+		{"over-long length", []byte{0xD0, 0x80, 0x00}, true, ErrMalformedLength, 2},
+		{"over-long length (lenient)", []byte{0xD0, 0x80, 0x00}, false, nil, 0},
+		{"too large", []byte{0x30, 0xFF, 0xFF, 0xFF, 0x7F}, false, ErrPacketTooLarge, 1},
+		{"reserved type", []byte{0xF0, 0x00}, false, ErrUnsupportedType, 0},
+		{"QoS 3", []byte{0x36, 0x05, 0x00, 0x01, 'a', 0x00, 0x01}, true, ErrInvalidQoS, 0},
+		{"pubrel flags", []byte{0x60, 0x02, 0x00, 0x01}, true, ErrReservedFlags, 0},
+		{"pingresp flags", []byte{0xD1, 0x00}, true, ErrReservedFlags, 0},
+		{"pingresp flags (lenient)", []byte{0xD1, 0x00}, false, nil, 0},
+		{"connack flags", []byte{0x20, 0x02, 0x02, 0x00}, true, ErrReservedFlags, 2},
+		{"trailing bytes", []byte{0x40, 0x03, 0x00, 0x01, 0xFF}, true, ErrTrailingBytes, 4},
+		{"invalid UTF-8", []byte{0x30, 0x05, 0x00, 0x03, 'a', 0xC3, 0x28}, true, ErrInvalidUTF8, 5},
+		{"null character", []byte{0x30, 0x03, 0x00, 0x01, 0x00}, true, ErrInvalidUTF8, 4},
+		{"invalid UTF-8 (lenient)", []byte{0x30, 0x05, 0x00, 0x03, 'a', 0xC3, 0x28}, false, nil, 0},
+		{"suback QoS", []byte{0x90, 0x04, 0x00, 0x01, 0x01, 0x03}, true, ErrInvalidQoS, 5},
+		{"subscribe QoS", []byte{0x82, 0x06, 0x00, 0x01, 0x00, 0x01, 'a', 0x03}, true, ErrInvalidQoS, 7},
+		{"truncated unsubscribe", []byte{0xA2, 0x06, 0x00, 0x01, 0x00, 0x01, 'a', 0x00}, true, io.ErrUnexpectedEOF, 7},
+		{"string overruns packet", []byte{0x30, 0x03, 0x00, 0x05, 'a'}, false, io.ErrUnexpectedEOF, 5},
+	}
+	for _, tt := range tests {
+		d := Decoder{MaxPacketSize: 1024, Strict: tt.strict}
+		cp, err := d.ReadPacket(bytes.NewReader(tt.data))
+		if tt.err == nil {
+			if err != nil || cp == nil {
+				t.Errorf("%s: unexpected error %v", tt.name, err)
+			}
+			continue
+		}
+		var de *DecodeError
+		if !errors.As(err, &de) || !errors.Is(err, tt.err) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.err, err)
+			continue
+		}
+		if de.Offset != tt.offset {
+			t.Errorf("%s: error at offset %d, expected %d (%s)", tt.name, de.Offset, tt.offset, err)
+		}
+	}
+
+	// A stream ending part way through a packet is not a decode error
+	d := Decoder{}
+	if _, err := d.ReadPacket(bytes.NewReader([]byte{0x30, 0xFF, 0xFF, 0x7F, 0x00})); err != io.ErrUnexpectedEOF {
+		t.Errorf("expected io.ErrUnexpectedEOF, got %v", err)
+	}
+}
+
+func TestDecoderBufferReuse(t *testing.T) {
+	for _, zeroCopy := range []bool{false, true} {
+		var buf bytes.Buffer
+		first := newBenchmarkPublish(10)
+		second := newBenchmarkPublish(10)
+		second.Payload = bytes.Repeat([]byte{'y'}, 10)
+		first.Write(&buf)
+		second.Write(&buf)
+		suback := NewControlPacket(Suback).(*SubackPacket)
+		suback.MessageID = 5
+		suback.ReturnCodes = []byte{0, 1, 2}
+		suback.Write(&buf)
+		ack := NewControlPacket(Puback).(*PubackPacket)
+		ack.MessageID = 0xFFFF
+		ack.Write(&buf)
+
+		d := Decoder{ZeroCopyPayload: zeroCopy}
+		var read []ControlPacket
+		for i := 0; i < 4; i++ {
+			cp, err := d.ReadPacket(&buf)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			read = append(read, cp)
+		}
+		// Earlier packets must not have been modified by reading later ones into the same buffer
+		for i, p := range []ControlPacket{first, second, suback, ack} {
+			if read[i].String() != p.String() {
+				t.Errorf("zeroCopy %t: packet %d read as %v, expected %v", zeroCopy, i, read[i], p)
+			}
+		}
+	}
+}
+
+// Regression (found by FuzzUnpack): Unpack allocated the payload length implied by RemainingLength before reading
+// it, so a large RemainingLength exhausted memory
+func TestUnpackLargeRemainingLength(t *testing.T) {
+	body := []byte{0x00, 0x01, 'a', 0x00, 0x01}
+	tests := []struct {
+		typ   byte
+		err   error
+		check func(cp ControlPacket) bool
+	}{
+		{Publish, io.EOF, func(cp ControlPacket) bool {
+			p := cp.(*PublishPacket)
+			return p.TopicName == "a" && p.MessageID == 1 && len(p.Payload) == 0
+		}},
+		{Subscribe, io.ErrUnexpectedEOF, func(cp ControlPacket) bool {
+			return len(cp.(*SubscribePacket).Topics) == 0
+		}},
+		{Suback, nil, func(cp ControlPacket) bool { // return codes are whatever follows the message id
+			return bytes.Equal(cp.(*SubackPacket).ReturnCodes, body[2:])
+		}},
+		{Unsubscribe, nil, func(cp ControlPacket) bool { // topics end at the first that cannot be decoded
+			return len(cp.(*UnsubscribePacket).Topics) == 0
+		}},
+	}
+	const maxAlloc = 1 << 20
+	for _, test := range tests {
+		cp, _ := NewControlPacketWithHeader(FixedHeader{MessageType: test.typ, Qos: 1, RemainingLength: 1 << 40})
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		err := cp.Unpack(bytes.NewReader(body))
+		runtime.ReadMemStats(&after)
+		if err != test.err {
+			t.Errorf("%s: expected error %v, got %v", PacketNames[test.typ], test.err, err)
+		}
+		if !test.check(cp) {
+			t.Errorf("%s: unexpected result %v", PacketNames[test.typ], cp)
+		}
+		if alloc := after.TotalAlloc - before.TotalAlloc; alloc > maxAlloc {
+			t.Errorf("%s: allocated %d bytes unpacking a 5 byte body", PacketNames[test.typ], alloc)
+		}
+	}
+}
+
+// randomString returns a valid MQTT UTF-8 string of up to max characters
+func randomString(rnd *rand.Rand, max int) string {
+	chars := []rune("abcXYZ019/+#$ _-\u00e9\u4e16\U0001F600")
+	r := make([]rune, rnd.Intn(max+1))
+	for i := range r {
+		r[i] = chars[rnd.Intn(len(chars))]
+	}
+	return string(r)
+}
+
+func randomBytes(rnd *rand.Rand, max int) []byte {
+	b := make([]byte, rnd.Intn(max+1))
+	rnd.Read(b)
+	return b
+}
+
+// randomPacket returns a packet of the type specified with random field values that conform to the specification
+func randomPacket(rnd *rand.Rand, typ byte) ControlPacket {
+	cp := NewControlPacket(typ)
+	id := uint16(rnd.Intn(65535) + 1)
+	switch p := cp.(type) {
+	case *ConnectPacket:
+		p.ProtocolName = "MQTT"
+		p.ProtocolVersion = 4
+		p.CleanSession = rnd.Intn(2) == 0
+		p.Keepalive = uint16(rnd.Intn(65536))
+		p.ClientIdentifier = randomString(rnd, 23)
+		if p.WillFlag = rnd.Intn(2) == 0; p.WillFlag {
+			p.WillQos = byte(rnd.Intn(3))
+			p.WillRetain = rnd.Intn(2) == 0
+			p.WillTopic = randomString(rnd, 20)
+			p.WillMessage = randomBytes(rnd, 50)
+		}
+		if p.UsernameFlag = rnd.Intn(2) == 0; p.UsernameFlag {
+			p.Username = randomString(rnd, 20)
+		}
+		if p.PasswordFlag = rnd.Intn(2) == 0; p.PasswordFlag {
+			p.Password = randomBytes(rnd, 20)
+		}
+	case *ConnackPacket:
+		p.SessionPresent = rnd.Intn(2) == 0
+		p.ReturnCode = byte(rnd.Intn(6))
+	case *PublishPacket:
+		p.Qos = byte(rnd.Intn(3))
+		p.Retain = rnd.Intn(2) == 0
+		if p.Qos > 0 {
+			p.Dup = rnd.Intn(2) == 0
+			p.MessageID = id
+		}
+		p.TopicName = randomString(rnd, 30)
+		p.Payload = randomBytes(rnd, 200)
+	case *PubackPacket:
+		p.MessageID = id
+	case *PubrecPacket:
+		p.MessageID = id
+	case *PubrelPacket:
+		p.MessageID = id
+	case *PubcompPacket:
+		p.MessageID = id
+	case *SubscribePacket:
+		p.MessageID = id
+		for i := rnd.Intn(4); i >= 0; i-- {
+			p.Topics = append(p.Topics, randomString(rnd, 20)+"t")
+			p.Qoss = append(p.Qoss, byte(rnd.Intn(3)))
+		}
+	case *SubackPacket:
+		p.MessageID = id
+		p.ReturnCodes = []byte{}
+		for i := rnd.Intn(4); i >= 0; i-- {
+			p.ReturnCodes = append(p.ReturnCodes, []byte{0, 1, 2, 0x80}[rnd.Intn(4)])
+		}
+	case *UnsubscribePacket:
+		p.MessageID = id
+		for i := rnd.Intn(4); i >= 0; i-- {
+			p.Topics = append(p.Topics, randomString(rnd, 20)+"t")
+		}
+	case *UnsubackPacket:
+		p.MessageID = id
+	}
+	return cp
+}
+
+func TestRandomRoundTrip(t *testing.T) {
+	rnd := rand.New(rand.NewSource(42))
+	d := Decoder{Strict: true}
+	var buf bytes.Buffer
+	for i := 0; i < 2000; i++ {
+		p := randomPacket(rnd, byte(i%Disconnect+1))
+		buf.Reset()
+		if err := p.Write(&buf); err != nil {
+			t.Fatalf("Write of %v returned error: %s", p, err)
+		}
+		read, err := d.ReadPacket(&buf)
+		if err != nil {
+			t.Fatalf("Read of %v returned error: %s", p, err)
+		}
+		if !reflect.DeepEqual(read, p) {
+			t.Fatalf("Read of packed %T did not equal original.\nExpected: %#v\n     Got: %#v", p, p, read)
+		}
+	}
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	rnd := rand.New(rand.NewSource(7))
+	for i := 0; i < 500; i++ {
+		p := randomPacket(rnd, byte(i%Disconnect+1))
+		for _, o := range []FormatOptions{{ShowPassword: true}, {ShowPassword: true, Hex: true}} {
+			b, err := ToJSON(p, o)
+			if err != nil {
+				t.Fatalf("ToJSON(%v) returned error: %s", p, err)
+			}
+			read, err := FromJSON(b)
+			if err != nil {
+				t.Fatalf("FromJSON(%s) returned error: %s", b, err)
+			}
+			if !reflect.DeepEqual(read, p) {
+				t.Fatalf("JSON round trip of %T did not equal original.\nExpected: %#v\n     Got: %#v\n    JSON: %s", p, p, read, b)
+			}
+		}
+	}
+}
+
+func TestJSON(t *testing.T) {
+	pub := NewControlPacket(Publish).(*PublishPacket)
+	pub.Qos, pub.MessageID, pub.TopicName, pub.Payload = 1, 1, "a/b", []byte("hi")
+	pub.Write(ioutil.Discard) // sets RemainingLength
+	b, err := json.Marshal(pub)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	want := `{"type":"PUBLISH","dup":false,"qos":1,"retain":false,"remaining_length":9,"message_id":1,"topic":"a/b","payload":{"base64":"aGk="}}`
+	if string(b) != want {
+		t.Errorf("PUBLISH encoded as %s, expected %s", b, want)
+	}
+	if b, _ = ToJSON(pub, FormatOptions{Hex: true}); !strings.Contains(string(b), `"payload":{"hex":"6869"}`) {
+		t.Errorf("PUBLISH encoded as %s with hex option", b)
+	}
+
+	var read PublishPacket
+	if err := json.Unmarshal([]byte(want), &read); err != nil || !reflect.DeepEqual(&read, pub) {
+		t.Errorf("unmarshal returned %v, %v", read, err)
+	}
+	var wrongType PubackPacket
+	if err := json.Unmarshal([]byte(want), &wrongType); err == nil {
+		t.Errorf("expected an error unmarshalling PUBLISH into PUBACK")
+	}
+
+	// Passwords are redacted by default
+	cp := NewControlPacket(Connect).(*ConnectPacket)
+	cp.ProtocolName, cp.ProtocolVersion, cp.UsernameFlag, cp.Username = "MQTT", 4, true, "user"
+	cp.PasswordFlag, cp.Password = true, []byte("secret")
+	if b, _ = json.Marshal(cp); strings.Contains(string(b), base64Secret) || !strings.Contains(string(b), `"password":{"redacted":true,"length":6}`) {
+		t.Errorf("CONNECT encoded as %s", b)
+	}
+	if strings.Contains(cp.String(), "secret") {
+		t.Errorf("String() included the password: %s", cp)
+	}
+	var buf bytes.Buffer
+	if PrettyPrint(&buf, cp, FormatOptions{}); strings.Contains(buf.String(), "secret") || !strings.Contains(buf.String(), "Password: <redacted, 6 bytes>") {
+		t.Errorf("PrettyPrint included the password:\n%s", buf.String())
+	}
+	if b, _ = ToJSON(cp, FormatOptions{ShowPassword: true}); !strings.Contains(string(b), base64Secret) {
+		t.Errorf("CONNECT encoded as %s with ShowPassword", b)
+	}
+}
+
+const base64Secret = "c2VjcmV0" // "secret"
+
+func TestPrettyPrint(t *testing.T) {
+	pub := NewControlPacket(Publish).(*PublishPacket)
+	pub.Qos, pub.MessageID, pub.TopicName, pub.Payload = 1, 1, "a/b", []byte("hi")
+	pub.Write(ioutil.Discard)
+	var buf bytes.Buffer
+	if err := PrettyPrint(&buf, pub, FormatOptions{}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	want := `PUBLISH
+    Header Flags: 0x32
+        0011 .... = Message Type: PUBLISH (3)
+        .... 0... = DUP Flag: Not set
+        .... .01. = QoS Level: 1
+        .... ...0 = Retain: Not set
+    Remaining Length: 9
+    Topic: a/b
+    Message Identifier: 1
+    Payload (2 bytes):
+        00000000  68 69                                             |hi|
+`
+	if buf.String() != want {
+		t.Errorf("PrettyPrint returned:\n%s\nexpected:\n%s", buf.String(), want)
+	}
+
+	// Binary payloads are not printed as raw bytes by String
+	pub.Payload = []byte{0x00, 0xff}
+	if s := pub.String(); !strings.HasSuffix(s, "payload: 0x00ff") {
+		t.Errorf("String() returned %q", s)
+	}
+}
+
+func newBenchmarkPublish(size int) *PublishPacket {
+	p := NewControlPacket(Publish).(*PublishPacket)
+	p.Qos = 1
+	p.MessageID = 1234
+	p.TopicName = "benchmark/topic/name"
+	p.Payload = bytes.Repeat([]byte{'x'}, size)
+	return p
+}
+
+func BenchmarkPublishWrite(b *testing.B) {
+	p := newBenchmarkPublish(256)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := p.Write(ioutil.Discard); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkPubackWrite(b *testing.B) {
+	p := NewControlPacket(Puback).(*PubackPacket)
+	p.MessageID = 1234
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := p.Write(ioutil.Discard); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func benchmarkRead(b *testing.B, p ControlPacket, d *Decoder) {
+	var buf bytes.Buffer
+	if err := p.Write(&buf); err != nil {
+		b.Fatal(err)
+	}
+	r := bytes.NewReader(buf.Bytes())
+	b.SetBytes(int64(buf.Len()))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r.Reset(buf.Bytes())
+		if _, err := d.ReadPacket(r); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkPublishRead(b *testing.B) {
+	benchmarkRead(b, newBenchmarkPublish(256), &Decoder{})
+}
+
+func BenchmarkPublishReadZeroCopy(b *testing.B) {
+	benchmarkRead(b, newBenchmarkPublish(256), &Decoder{ZeroCopyPayload: true})
+}
+
+func BenchmarkPublishReadLarge(b *testing.B) {
+	benchmarkRead(b, newBenchmarkPublish(32*1024), &Decoder{})
+}
+
+func BenchmarkPublishReadLargeZeroCopy(b *testing.B) {
+	benchmarkRead(b, newBenchmarkPublish(32*1024), &Decoder{ZeroCopyPayload: true})
+}
+
+func BenchmarkPubackRead(b *testing.B) {
+	p := NewControlPacket(Puback).(*PubackPacket)
+	p.MessageID = 1234
+	benchmarkRead(b, p, &Decoder{})
+}
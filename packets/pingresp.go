@@ -15,10 +15,9 @@ func (pr *PingrespPacket) String() string {
 }
 
 func (pr *PingrespPacket) Write(w io.Writer) error {
-	packet := pr.FixedHeader.pack()
-	_, err := packet.WriteTo(w)
-
-	return err
+	bp := getBuffer(2)
+	b := pr.FixedHeader.appendHeader(*bp)
+	return writeBuffer(w, bp, b)
 }
 
 // Unpack decodes the details of a ControlPacket after the fixed
@@ -27,6 +26,10 @@ func (pr *PingrespPacket) Unpack(b io.Reader) error {
 	return nil
 }
 
+func (pr *PingrespPacket) unpackFrame(_ []byte, _ bool) (int, error) {
+	return 0, nil
+}
+
 // Details returns a Details struct containing the Qos and
 // MessageID of this ControlPacket
 func (pr *PingrespPacket) Details() Details {
@@ -0,0 +1,135 @@
+package packets
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// PrettyPrint writes a multi-line, human readable description of the packet to w in a similar format to that used
+// by packet analysers such as Wireshark. The flags in the fixed header (and CONNECT flags) are broken down bit by
+// bit and binary fields are shown as a hex dump. The password is redacted unless o.ShowPassword is true.
+//
+// For example:
+//
+//	PUBLISH
+//	    Header Flags: 0x32
+//	        0011 .... = Message Type: PUBLISH (3)
+//	        .... 0... = DUP Flag: Not set
+//	        .... .01. = QoS Level: 1
+//	        .... ...0 = Retain: Not set
+//	    Remaining Length: 9
+//	    Topic: a/b
+//	    Message Identifier: 1
+//	    Payload (2 bytes):
+//	        00000000  68 69                                             |hi|
+func PrettyPrint(w io.Writer, cp ControlPacket, o FormatOptions) error {
+	bw := bufio.NewWriter(w)
+	line := func(indent int, format string, a ...interface{}) {
+		bw.WriteString(strings.Repeat("    ", indent))
+		fmt.Fprintf(bw, format, a...)
+		bw.WriteByte('\n')
+	}
+	dump := func(indent int, name string, b []byte) {
+		line(indent, "%s (%d bytes):", name, len(b))
+		for _, l := range strings.Split(strings.TrimSuffix(hex.Dump(b), "\n"), "\n") {
+			if l != "" {
+				line(indent+1, "%s", l)
+			}
+		}
+	}
+
+	j, err := toPacketJSON(cp, o)
+	if err != nil {
+		return err
+	}
+	fh := FixedHeader{Dup: j.Dup, Qos: j.Qos, Retain: j.Retain, RemainingLength: j.RemainingLength}
+	for t, name := range PacketNames {
+		if name == j.Type {
+			fh.MessageType = t
+		}
+	}
+
+	flags := fh.MessageType<<4 | boolToByte(fh.Dup)<<3 | fh.Qos<<1 | boolToByte(fh.Retain)
+	line(0, "%s", j.Type)
+	line(1, "Header Flags: 0x%02x", flags)
+	line(2, "%04b .... = Message Type: %s (%d)", fh.MessageType, j.Type, fh.MessageType)
+	line(2, ".... %d... = DUP Flag: %s", boolToByte(fh.Dup), setOrNot(fh.Dup))
+	line(2, ".... .%02b. = QoS Level: %d", fh.Qos, fh.Qos)
+	line(2, ".... ...%d = Retain: %s", boolToByte(fh.Retain), setOrNot(fh.Retain))
+	line(1, "Remaining Length: %d", fh.RemainingLength)
+
+	switch p := cp.(type) {
+	case *ConnectPacket:
+		line(1, "Protocol Name: %s", p.ProtocolName)
+		line(1, "Protocol Version: %d", p.ProtocolVersion)
+		cf := boolToByte(p.UsernameFlag)<<7 | boolToByte(p.PasswordFlag)<<6 | boolToByte(p.WillRetain)<<5 |
+			p.WillQos<<3 | boolToByte(p.WillFlag)<<2 | boolToByte(p.CleanSession)<<1 | p.ReservedBit
+		line(1, "Connect Flags: 0x%02x", cf)
+		line(2, "%d... .... = User Name Flag: %s", boolToByte(p.UsernameFlag), setOrNot(p.UsernameFlag))
+		line(2, ".%d.. .... = Password Flag: %s", boolToByte(p.PasswordFlag), setOrNot(p.PasswordFlag))
+		line(2, "..%d. .... = Will Retain: %s", boolToByte(p.WillRetain), setOrNot(p.WillRetain))
+		line(2, "...%d %d... = Will QoS Level: %d", p.WillQos>>1&1, p.WillQos&1, p.WillQos)
+		line(2, ".... .%d.. = Will Flag: %s", boolToByte(p.WillFlag), setOrNot(p.WillFlag))
+		line(2, ".... ..%d. = Clean Session Flag: %s", boolToByte(p.CleanSession), setOrNot(p.CleanSession))
+		line(2, ".... ...%d = (Reserved): %s", p.ReservedBit, setOrNot(p.ReservedBit != 0))
+		line(1, "Keep Alive: %d", p.Keepalive)
+		line(1, "Client ID: %s", p.ClientIdentifier)
+		if p.WillFlag {
+			line(1, "Will Topic: %s", p.WillTopic)
+			dump(1, "Will Message", p.WillMessage)
+		}
+		if p.UsernameFlag {
+			line(1, "User Name: %s", p.Username)
+		}
+		if p.PasswordFlag {
+			if o.ShowPassword {
+				dump(1, "Password", p.Password)
+			} else {
+				line(1, "Password: %s", redactedPassword(p.Password))
+			}
+		}
+	case *ConnackPacket:
+		line(1, "Session Present: %s", setOrNot(p.SessionPresent))
+		line(1, "Return Code: %s (%d)", ConnackReturnCodes[p.ReturnCode], p.ReturnCode)
+	case *PublishPacket:
+		line(1, "Topic: %s", p.TopicName)
+		if p.Qos > 0 {
+			line(1, "Message Identifier: %d", p.MessageID)
+		}
+		dump(1, "Payload", p.Payload)
+	case *SubscribePacket:
+		line(1, "Message Identifier: %d", p.MessageID)
+		for _, s := range j.Subscriptions {
+			line(1, "Topic: %s (QoS %d)", s.Topic, s.Qos)
+		}
+	case *SubackPacket:
+		line(1, "Message Identifier: %d", p.MessageID)
+		for _, rc := range p.ReturnCodes {
+			if rc == 0x80 {
+				line(1, "Return Code: Failure (0x80)")
+			} else {
+				line(1, "Return Code: Granted QoS %d", rc)
+			}
+		}
+	case *UnsubscribePacket:
+		line(1, "Message Identifier: %d", p.MessageID)
+		for _, t := range p.Topics {
+			line(1, "Topic: %s", t)
+		}
+	default:
+		if j.MessageID != nil {
+			line(1, "Message Identifier: %d", *j.MessageID)
+		}
+	}
+	return bw.Flush()
+}
+
+func setOrNot(b bool) string {
+	if b {
+		return "Set"
+	}
+	return "Not set"
+}
@@ -17,13 +17,11 @@ func (pa *PubackPacket) String() string {
 }
 
 func (pa *PubackPacket) Write(w io.Writer) error {
-	var err error
 	pa.FixedHeader.RemainingLength = 2
-	packet := pa.FixedHeader.pack()
-	packet.Write(encodeUint16(pa.MessageID))
-	_, err = packet.WriteTo(w)
-
-	return err
+	bp := getBuffer(4)
+	b := pa.FixedHeader.appendHeader(*bp)
+	b = appendUint16(b, pa.MessageID)
+	return writeBuffer(w, bp, b)
 }
 
 // Unpack decodes the details of a ControlPacket after the fixed
@@ -35,6 +33,13 @@ func (pa *PubackPacket) Unpack(b io.Reader) error {
 	return err
 }
 
+func (pa *PubackPacket) unpackFrame(b []byte, _ bool) (int, error) {
+	var n int
+	var err error
+	pa.MessageID, n, err = frameUint16(b, 0)
+	return n, err
+}
+
 // Details returns a Details struct containing the Qos and
 // MessageID of this ControlPacket
 func (pa *PubackPacket) Details() Details {
@@ -17,13 +17,11 @@ func (pc *PubcompPacket) String() string {
 }
 
 func (pc *PubcompPacket) Write(w io.Writer) error {
-	var err error
 	pc.FixedHeader.RemainingLength = 2
-	packet := pc.FixedHeader.pack()
-	packet.Write(encodeUint16(pc.MessageID))
-	_, err = packet.WriteTo(w)
-
-	return err
+	bp := getBuffer(4)
+	b := pc.FixedHeader.appendHeader(*bp)
+	b = appendUint16(b, pc.MessageID)
+	return writeBuffer(w, bp, b)
 }
 
 // Unpack decodes the details of a ControlPacket after the fixed
@@ -35,6 +33,13 @@ func (pc *PubcompPacket) Unpack(b io.Reader) error {
 	return err
 }
 
+func (pc *PubcompPacket) unpackFrame(b []byte, _ bool) (int, error) {
+	var n int
+	var err error
+	pc.MessageID, n, err = frameUint16(b, 0)
+	return n, err
+}
+
 // Details returns a Details struct containing the Qos and
 // MessageID of this ControlPacket
 func (pc *PubcompPacket) Details() Details {
@@ -1,7 +1,6 @@
 package packets
 
 import (
-	"bytes"
 	"fmt"
 	"io"
 )
@@ -16,24 +15,22 @@ type PublishPacket struct {
 }
 
 func (p *PublishPacket) String() string {
-	return fmt.Sprintf("%s topicName: %s MessageID: %d payload: %s", p.FixedHeader, p.TopicName, p.MessageID, string(p.Payload))
+	return fmt.Sprintf("%s topicName: %s MessageID: %d payload: %s", p.FixedHeader, p.TopicName, p.MessageID, printable(p.Payload))
 }
 
 func (p *PublishPacket) Write(w io.Writer) error {
-	var body bytes.Buffer
-	var err error
-
-	body.Write(encodeString(p.TopicName))
+	p.FixedHeader.RemainingLength = 2 + len(p.TopicName) + len(p.Payload)
 	if p.Qos > 0 {
-		body.Write(encodeUint16(p.MessageID))
+		p.FixedHeader.RemainingLength += 2
 	}
-	p.FixedHeader.RemainingLength = body.Len() + len(p.Payload)
-	packet := p.FixedHeader.pack()
-	packet.Write(body.Bytes())
-	packet.Write(p.Payload)
-	_, err = w.Write(packet.Bytes())
-
-	return err
+	bp := getBuffer(5 + p.FixedHeader.RemainingLength)
+	b := p.FixedHeader.appendHeader(*bp)
+	b = appendString(b, p.TopicName)
+	if p.Qos > 0 {
+		b = appendUint16(b, p.MessageID)
+	}
+	b = append(b, p.Payload...)
+	return writeBuffer(w, bp, b)
 }
 
 // Unpack decodes the details of a ControlPacket after the fixed
@@ -58,12 +55,32 @@ func (p *PublishPacket) Unpack(b io.Reader) error {
 	if payloadLength < 0 {
 		return fmt.Errorf("error unpacking publish, payload length < 0")
 	}
-	p.Payload = make([]byte, payloadLength)
-	_, err = b.Read(p.Payload)
+	p.Payload, err = readBytes(b, payloadLength)
 
 	return err
 }
 
+// unpackFrame decodes the packet from b; the payload references b if zeroCopy is true
+func (p *PublishPacket) unpackFrame(b []byte, zeroCopy bool) (int, error) {
+	topic, n, err := frameBytes(b, 0)
+	if err != nil {
+		return n, err
+	}
+	p.TopicName = string(topic)
+	if p.Qos > 0 {
+		if p.MessageID, n, err = frameUint16(b, n); err != nil {
+			return n, err
+		}
+	}
+	if zeroCopy {
+		p.Payload = b[n:len(b):len(b)]
+	} else {
+		p.Payload = make([]byte, len(b)-n)
+		copy(p.Payload, b[n:])
+	}
+	return len(b), nil
+}
+
 // Copy creates a new PublishPacket with the same topic and payload
 // but an empty fixed header, useful for when you want to deliver
 // a message with different properties such as Qos but the same
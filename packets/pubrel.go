@@ -17,13 +17,11 @@ func (pr *PubrelPacket) String() string {
 }
 
 func (pr *PubrelPacket) Write(w io.Writer) error {
-	var err error
 	pr.FixedHeader.RemainingLength = 2
-	packet := pr.FixedHeader.pack()
-	packet.Write(encodeUint16(pr.MessageID))
-	_, err = packet.WriteTo(w)
-
-	return err
+	bp := getBuffer(4)
+	b := pr.FixedHeader.appendHeader(*bp)
+	b = appendUint16(b, pr.MessageID)
+	return writeBuffer(w, bp, b)
 }
 
 // Unpack decodes the details of a ControlPacket after the fixed
@@ -35,6 +33,13 @@ func (pr *PubrelPacket) Unpack(b io.Reader) error {
 	return err
 }
 
+func (pr *PubrelPacket) unpackFrame(b []byte, _ bool) (int, error) {
+	var n int
+	var err error
+	pr.MessageID, n, err = frameUint16(b, 0)
+	return n, err
+}
+
 // Details returns a Details struct containing the Qos and
 // MessageID of this ControlPacket
 func (pr *PubrelPacket) Details() Details {
@@ -19,16 +19,12 @@ func (sa *SubackPacket) String() string {
 }
 
 func (sa *SubackPacket) Write(w io.Writer) error {
-	var body bytes.Buffer
-	var err error
-	body.Write(encodeUint16(sa.MessageID))
-	body.Write(sa.ReturnCodes)
-	sa.FixedHeader.RemainingLength = body.Len()
-	packet := sa.FixedHeader.pack()
-	packet.Write(body.Bytes())
-	_, err = packet.WriteTo(w)
-
-	return err
+	sa.FixedHeader.RemainingLength = 2 + len(sa.ReturnCodes)
+	bp := getBuffer(5 + sa.FixedHeader.RemainingLength)
+	b := sa.FixedHeader.appendHeader(*bp)
+	b = appendUint16(b, sa.MessageID)
+	b = append(b, sa.ReturnCodes...)
+	return writeBuffer(w, bp, b)
 }
 
 // Unpack decodes the details of a ControlPacket after the fixed
@@ -50,6 +46,16 @@ func (sa *SubackPacket) Unpack(b io.Reader) error {
 	return nil
 }
 
+func (sa *SubackPacket) unpackFrame(b []byte, _ bool) (int, error) {
+	var n int
+	var err error
+	if sa.MessageID, n, err = frameUint16(b, 0); err != nil {
+		return n, err
+	}
+	sa.ReturnCodes = append([]byte{}, b[n:]...)
+	return len(b), nil
+}
+
 // Details returns a Details struct containing the Qos and
 // MessageID of this ControlPacket
 func (sa *SubackPacket) Details() Details {
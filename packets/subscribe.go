@@ -1,7 +1,6 @@
 package packets
 
 import (
-	"bytes"
 	"fmt"
 	"io"
 )
@@ -20,20 +19,18 @@ func (s *SubscribePacket) String() string {
 }
 
 func (s *SubscribePacket) Write(w io.Writer) error {
-	var body bytes.Buffer
-	var err error
-
-	body.Write(encodeUint16(s.MessageID))
+	s.FixedHeader.RemainingLength = 2
+	for _, topic := range s.Topics {
+		s.FixedHeader.RemainingLength += 2 + len(topic) + 1
+	}
+	bp := getBuffer(5 + s.FixedHeader.RemainingLength)
+	b := s.FixedHeader.appendHeader(*bp)
+	b = appendUint16(b, s.MessageID)
 	for i, topic := range s.Topics {
-		body.Write(encodeString(topic))
-		body.WriteByte(s.Qoss[i])
+		b = appendString(b, topic)
+		b = append(b, s.Qoss[i])
 	}
-	s.FixedHeader.RemainingLength = body.Len()
-	packet := s.FixedHeader.pack()
-	packet.Write(body.Bytes())
-	_, err = packet.WriteTo(w)
-
-	return err
+	return writeBuffer(w, bp, b)
 }
 
 // Unpack decodes the details of a ControlPacket after the fixed
@@ -17,13 +17,11 @@ func (ua *UnsubackPacket) String() string {
 }
 
 func (ua *UnsubackPacket) Write(w io.Writer) error {
-	var err error
 	ua.FixedHeader.RemainingLength = 2
-	packet := ua.FixedHeader.pack()
-	packet.Write(encodeUint16(ua.MessageID))
-	_, err = packet.WriteTo(w)
-
-	return err
+	bp := getBuffer(4)
+	b := ua.FixedHeader.appendHeader(*bp)
+	b = appendUint16(b, ua.MessageID)
+	return writeBuffer(w, bp, b)
 }
 
 // Unpack decodes the details of a ControlPacket after the fixed
@@ -35,6 +33,13 @@ func (ua *UnsubackPacket) Unpack(b io.Reader) error {
 	return err
 }
 
+func (ua *UnsubackPacket) unpackFrame(b []byte, _ bool) (int, error) {
+	var n int
+	var err error
+	ua.MessageID, n, err = frameUint16(b, 0)
+	return n, err
+}
+
 // Details returns a Details struct containing the Qos and
 // MessageID of this ControlPacket
 func (ua *UnsubackPacket) Details() Details {
@@ -1,7 +1,6 @@
 package packets
 
 import (
-	"bytes"
 	"fmt"
 	"io"
 )
@@ -19,18 +18,17 @@ func (u *UnsubscribePacket) String() string {
 }
 
 func (u *UnsubscribePacket) Write(w io.Writer) error {
-	var body bytes.Buffer
-	var err error
-	body.Write(encodeUint16(u.MessageID))
+	u.FixedHeader.RemainingLength = 2
 	for _, topic := range u.Topics {
-		body.Write(encodeString(topic))
+		u.FixedHeader.RemainingLength += 2 + len(topic)
 	}
-	u.FixedHeader.RemainingLength = body.Len()
-	packet := u.FixedHeader.pack()
-	packet.Write(body.Bytes())
-	_, err = packet.WriteTo(w)
-
-	return err
+	bp := getBuffer(5 + u.FixedHeader.RemainingLength)
+	b := u.FixedHeader.appendHeader(*bp)
+	b = appendUint16(b, u.MessageID)
+	for _, topic := range u.Topics {
+		b = appendString(b, topic)
+	}
+	return writeBuffer(w, bp, b)
 }
 
 // Unpack decodes the details of a ControlPacket after the fixed
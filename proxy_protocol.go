@@ -0,0 +1,111 @@
+package mqtt
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+)
+
+// ProxyProtocolHeader configures the PROXY protocol (as used by HAProxy and many load balancers) header that
+// is written at the start of each connection, before any TLS or websocket handshake. This tells the broker
+// (via the load balancer) the address of the client; it can also be used to emulate multiple client addresses
+// when testing. See https://www.haproxy.org/download/2.0/doc/proxy-protocol.txt
+type ProxyProtocolHeader struct {
+	Version     int          // 1 (text) or 2 (binary)
+	Source      *net.TCPAddr // address of the client; if nil the local address of the connection is used
+	Destination *net.TCPAddr // address connected to; if nil the remote address of the connection is used
+}
+
+// proxyProtocolV2Signature starts every PROXY protocol version 2 header
+var proxyProtocolV2Signature = []byte{0x0D, 0x0A, 0x0D, 0x0A, 0x00, 0x0D, 0x0A, 0x51, 0x55, 0x49, 0x54, 0x0A}
+
+// encode returns the header for a connection from src to dst
+func (h *ProxyProtocolHeader) encode(src, dst *net.TCPAddr) ([]byte, error) {
+	if h.Source != nil {
+		src = h.Source
+	}
+	if h.Destination != nil {
+		dst = h.Destination
+	}
+	if src == nil || dst == nil {
+		return nil, fmt.Errorf("PROXY protocol requires TCP source and destination addresses")
+	}
+	srcIP, dstIP := src.IP.To4(), dst.IP.To4()
+	ipv4 := srcIP != nil && dstIP != nil
+	if !ipv4 { // both addresses must be the same family
+		srcIP, dstIP = src.IP.To16(), dst.IP.To16()
+		if srcIP == nil || dstIP == nil {
+			return nil, fmt.Errorf("invalid PROXY protocol address")
+		}
+	}
+
+	var b bytes.Buffer
+	switch h.Version {
+	case 1:
+		family := "TCP4"
+		if !ipv4 {
+			family = "TCP6"
+		}
+		b.WriteString("PROXY " + family + " " + proxyProtocolIP(srcIP, ipv4) + " " + proxyProtocolIP(dstIP, ipv4) + " " +
+			strconv.Itoa(src.Port) + " " + strconv.Itoa(dst.Port) + "\r\n")
+	case 2:
+		b.Write(proxyProtocolV2Signature)
+		b.WriteByte(0x21) // version 2, PROXY command
+		if ipv4 {
+			b.WriteByte(0x11) // AF_INET, STREAM
+			binary.Write(&b, binary.BigEndian, uint16(12))
+		} else {
+			b.WriteByte(0x21) // AF_INET6, STREAM
+			binary.Write(&b, binary.BigEndian, uint16(36))
+		}
+		b.Write(srcIP)
+		b.Write(dstIP)
+		binary.Write(&b, binary.BigEndian, uint16(src.Port))
+		binary.Write(&b, binary.BigEndian, uint16(dst.Port))
+	default:
+		return nil, fmt.Errorf("unsupported PROXY protocol version %d", h.Version)
+	}
+	return b.Bytes(), nil
+}
+
+// proxyProtocolIP formats ip for a version 1 header; IPv4 addresses sent as TCP6 must be written in
+// IPv6 form (net.IP.String would write them as IPv4)
+func proxyProtocolIP(ip net.IP, ipv4 bool) string {
+	if !ipv4 && ip.To4() != nil {
+		return "::ffff:" + ip.To4().String()
+	}
+	return ip.String()
+}
+
+// proxyProtocolDialer writes a PROXY protocol header to each connection opened by forward
+type proxyProtocolDialer struct {
+	header      *ProxyProtocolHeader
+	forward     ContextDialer
+	environment bool // forward is the default dialer (websocket connections may use the HTTP proxy from the environment)
+}
+
+// DialContext opens a connection and writes the header
+func (d *proxyProtocolDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	conn, err := d.forward.DialContext(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	src, _ := conn.LocalAddr().(*net.TCPAddr)
+	dst, _ := conn.RemoteAddr().(*net.TCPAddr)
+	header, err := d.header.encode(src, dst)
+	if err == nil {
+		stop := closeOnDone(ctx, conn)
+		_, err = conn.Write(header)
+		if stopped := stop(); !stopped && err == nil {
+			err = ctx.Err()
+		}
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
@@ -0,0 +1,236 @@
+package mqtt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+// RawSessionOptions configures a RawSession
+type RawSessionOptions struct {
+	AutoPingResponse bool          // reply to PINGREQ packets with PINGRESP (the PINGREQ is not returned by Receive)
+	WriteTimeout     time.Duration // limit on the time taken by each write (0 means no limit)
+	MaxPacketSize    int           // maximum size of packets received (0 means no limit); see packets.Decoder
+	StrictDecoding   bool          // reject received packets that do not conform to the specification
+}
+
+// ErrUnexpectedPacket is returned by RawSession.Expect when a packet of a different type is received
+var ErrUnexpectedPacket = errors.New("unexpected packet")
+
+// ErrRawSessionClosed is returned by RawSession.Receive once Close has been called
+var ErrRawSessionClosed = errors.New("raw session closed")
+
+// RawSession provides low level access to an MQTT connection for testing and protocol research. Unlike the
+// Client it does not manage the session in any way (no keepalive, message ids, acknowledgements or
+// persistence); packets are sent exactly as provided (including invalid header flags) and everything received
+// is returned to the caller. Packets are read in the background so Receive can be abandoned (e.g. on timeout)
+// without losing data. Send and Receive may be called concurrently.
+type RawSession struct {
+	conn    net.Conn
+	options RawSessionOptions
+
+	writeMu sync.Mutex
+	in      chan rawInbound
+	done    chan struct{} // closed when the reader exits (err and errFrame are then set)
+	closed  chan struct{} // closed by Close
+	once    sync.Once
+
+	err      error
+	errFrame []byte
+}
+
+// rawInbound is a packet received by a RawSession along with the bytes it was decoded from
+type rawInbound struct {
+	cp    packets.ControlPacket
+	frame []byte
+}
+
+// NewRawSession starts a session on conn (which should be connected but must not have been used for MQTT)
+func NewRawSession(conn net.Conn, o RawSessionOptions) *RawSession {
+	s := &RawSession{
+		conn:    conn,
+		options: o,
+		in:      make(chan rawInbound),
+		done:    make(chan struct{}),
+		closed:  make(chan struct{}),
+	}
+	go s.read()
+	return s
+}
+
+// read receives packets until an error occurs (malformed packets are fatal as the stream cannot be resynchronised)
+func (s *RawSession) read() {
+	defer close(s.done)
+	rec := &recordingReader{r: s.conn}
+	dec := &packets.Decoder{MaxPacketSize: s.options.MaxPacketSize, Strict: s.options.StrictDecoding}
+	for {
+		rec.frame = nil
+		cp, err := dec.ReadPacket(rec)
+		if err != nil {
+			s.err, s.errFrame = s.closedErr(err), rec.frame
+			return
+		}
+		if _, ok := cp.(*packets.PingreqPacket); ok && s.options.AutoPingResponse {
+			DEBUG.Println(NET, "raw session responding to PINGREQ")
+			if err = s.Send(packets.NewControlPacket(packets.Pingresp)); err != nil {
+				s.err = s.closedErr(err)
+				return
+			}
+			continue
+		}
+		select {
+		case s.in <- rawInbound{cp: cp, frame: rec.frame}:
+		case <-s.closed:
+			s.err = ErrRawSessionClosed
+			return
+		}
+	}
+}
+
+// closedErr returns ErrRawSessionClosed if Close has been called (in which case err is the result of closing the
+// connection), otherwise err
+func (s *RawSession) closedErr(err error) error {
+	select {
+	case <-s.closed:
+		return ErrRawSessionClosed
+	default:
+		return err
+	}
+}
+
+// Send writes the packet to the connection. The fixed header flags (Dup, Qos and Retain) are sent as set in
+// the packet even if they are not valid for its type.
+func (s *RawSession) Send(cp packets.ControlPacket) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	s.setWriteDeadline()
+	return cp.Write(s.conn)
+}
+
+// SendWithFlags writes the packet with the flags (lower four bits) of the fixed header replaced by flags
+func (s *RawSession) SendWithFlags(cp packets.ControlPacket, flags byte) error {
+	w := &recordingReader{}
+	if err := cp.Write(w); err != nil {
+		return err
+	}
+	w.frame[0] = w.frame[0]&0xF0 | flags&0x0F
+	return s.SendBytes(w.frame)
+}
+
+// SendBytes writes b to the connection unchanged
+func (s *RawSession) SendBytes(b []byte) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	s.setWriteDeadline()
+	_, err := s.conn.Write(b)
+	return err
+}
+
+func (s *RawSession) setWriteDeadline() {
+	if s.options.WriteTimeout > 0 {
+		s.conn.SetWriteDeadline(time.Now().Add(s.options.WriteTimeout))
+	}
+}
+
+// Receive returns the next packet received, waiting until one arrives, the connection fails or ctx is done
+func (s *RawSession) Receive(ctx context.Context) (packets.ControlPacket, error) {
+	cp, _, err := s.ReceiveFrame(ctx)
+	return cp, err
+}
+
+// ReceiveFrame is the same as Receive but also returns the bytes that the packet was decoded from. If a
+// malformed packet is received the error (a *packets.DecodeError) is returned along with the bytes read.
+// Once the connection has failed every call returns the same error.
+func (s *RawSession) ReceiveFrame(ctx context.Context) (packets.ControlPacket, []byte, error) {
+	select {
+	case p := <-s.in:
+		return p.cp, p.frame, nil
+	case <-s.done:
+		return nil, s.errFrame, s.err
+	case <-ctx.Done():
+		return nil, nil, ctx.Err()
+	}
+}
+
+// Expect waits up to timeout for the next packet and returns an error wrapping ErrUnexpectedPacket (along with
+// the packet) if it is not of the type specified (e.g. packets.Connack)
+func (s *RawSession) Expect(packetType byte, timeout time.Duration) (packets.ControlPacket, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	cp, err := s.Receive(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("waiting for %s: %w", packets.PacketNames[packetType], err)
+	}
+	if got := packetTypeOf(cp); got != packetType {
+		return cp, fmt.Errorf("%w: expected %s but received %s", ErrUnexpectedPacket, packets.PacketNames[packetType], packets.PacketNames[got])
+	}
+	return cp, nil
+}
+
+// Close closes the connection
+func (s *RawSession) Close() error {
+	var err error
+	s.once.Do(func() {
+		close(s.closed)
+		err = s.conn.Close()
+	})
+	return err
+}
+
+// packetTypeOf returns the type of the packet (e.g. packets.Publish)
+func packetTypeOf(cp packets.ControlPacket) byte {
+	switch cp.(type) {
+	case *packets.ConnectPacket:
+		return packets.Connect
+	case *packets.ConnackPacket:
+		return packets.Connack
+	case *packets.PublishPacket:
+		return packets.Publish
+	case *packets.PubackPacket:
+		return packets.Puback
+	case *packets.PubrecPacket:
+		return packets.Pubrec
+	case *packets.PubrelPacket:
+		return packets.Pubrel
+	case *packets.PubcompPacket:
+		return packets.Pubcomp
+	case *packets.SubscribePacket:
+		return packets.Subscribe
+	case *packets.SubackPacket:
+		return packets.Suback
+	case *packets.UnsubscribePacket:
+		return packets.Unsubscribe
+	case *packets.UnsubackPacket:
+		return packets.Unsuback
+	case *packets.PingreqPacket:
+		return packets.Pingreq
+	case *packets.PingrespPacket:
+		return packets.Pingresp
+	case *packets.DisconnectPacket:
+		return packets.Disconnect
+	}
+	return 0
+}
+
+// recordingReader retains the bytes read from r (or written to it)
+type recordingReader struct {
+	r     io.Reader
+	frame []byte
+}
+
+func (r *recordingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.frame = append(r.frame, p[:n]...)
+	return n, err
+}
+
+func (r *recordingReader) Write(p []byte) (int, error) {
+	r.frame = append(r.frame, p...)
+	return len(p), nil
+}
@@ -0,0 +1,255 @@
+package mqtt
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+// Record is a single message within a recording.
+//
+// A recording is a stream of received messages stored in the "JSON lines" format; that is, each
+// message is encoded as a single JSON object terminated by a newline. This means that recordings
+// can be written and read incrementally (and that tools such as jq can be used to process them).
+// Each line contains the following fields:
+//
+//	time       - time at which the message arrived (RFC 3339 with nanoseconds)
+//	topic      - topic the message was published to
+//	qos        - QoS the message was received at (0, 1 or 2)
+//	retained   - true if the retained flag was set
+//	duplicate  - true if the duplicate flag was set (omitted if false)
+//	message_id - packet identifier assigned by the broker (omitted if 0)
+//	payload    - the message payload (base64 encoded)
+//	frame      - the PUBLISH packet re-encoded from the message fields (base64 encoded, optional)
+//
+// The frame is rebuilt from the received message rather than captured from the connection, so it
+// will not reflect the exact bytes sent by the broker (e.g. unusual header flags or a remaining
+// length encoded with more bytes than required). When a frame is present the other message fields
+// may be omitted; they will be populated by decoding the frame when the recording is read.
+//
+// For example:
+//
+//	{"time":"2020-06-01T10:00:00.000000001Z","topic":"a/b","qos":1,"retained":false,"message_id":3,"payload":"aGVsbG8="}
+type Record struct {
+	Time      time.Time `json:"time"`
+	Topic     string    `json:"topic"`
+	Qos       byte      `json:"qos"`
+	Retained  bool      `json:"retained"`
+	Duplicate bool      `json:"duplicate,omitempty"`
+	MessageID uint16    `json:"message_id,omitempty"`
+	Payload   []byte    `json:"payload"`
+	Frame     []byte    `json:"frame,omitempty"`
+}
+
+// RecordFromMessage creates a Record from a received message. If rawFrame is true then the
+// PUBLISH packet will be re-encoded from the message and stored in the Frame field.
+func RecordFromMessage(m Message, at time.Time, rawFrame bool) (*Record, error) {
+	r := &Record{
+		Time:      at,
+		Topic:     m.Topic(),
+		Qos:       m.Qos(),
+		Retained:  m.Retained(),
+		Duplicate: m.Duplicate(),
+		MessageID: m.MessageID(),
+		Payload:   m.Payload(),
+	}
+	if rawFrame {
+		var buf bytes.Buffer
+		if err := r.publishPacket().Write(&buf); err != nil {
+			return nil, err
+		}
+		r.Frame = buf.Bytes()
+	}
+	return r, nil
+}
+
+// publishPacket returns a PUBLISH packet containing the details of the recorded message
+func (r *Record) publishPacket() *packets.PublishPacket {
+	pub := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+	pub.TopicName = r.Topic
+	pub.Qos = r.Qos
+	pub.Retain = r.Retained
+	pub.Dup = r.Duplicate
+	pub.MessageID = r.MessageID
+	pub.Payload = r.Payload
+	return pub
+}
+
+// Message returns a Message containing the recorded details; calling Ack on it has no effect
+func (r *Record) Message() Message {
+	return messageFromPublish(r.publishPacket(), func() {})
+}
+
+// RecordWriter writes a recording to an io.Writer. It is safe for concurrent use by multiple goroutines.
+type RecordWriter struct {
+	mu       sync.Mutex
+	w        *bufio.Writer
+	enc      *json.Encoder
+	rawFrame bool
+	err      error // first error encountered by Handler
+}
+
+// NewRecordWriter returns a RecordWriter that writes to w. If rawFrames is true then the Frame field
+// of each record will be populated by Handler.
+func NewRecordWriter(w io.Writer, rawFrames bool) *RecordWriter {
+	bw := bufio.NewWriter(w)
+	return &RecordWriter{w: bw, enc: json.NewEncoder(bw), rawFrame: rawFrames}
+}
+
+// Write adds a record to the recording
+func (rw *RecordWriter) Write(r *Record) error {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	if err := rw.enc.Encode(r); err != nil {
+		return err
+	}
+	return rw.w.Flush() // records are flushed immediately so the recording survives a crash
+}
+
+// Handler returns a MessageHandler that records every message it receives; it may be passed to
+// Subscribe, AddRoute or SetDefaultPublishHandler. As handlers cannot return errors the first
+// error encountered is retained and can be retrieved with Err.
+func (rw *RecordWriter) Handler() MessageHandler {
+	return func(_ Client, m Message) {
+		r, err := RecordFromMessage(m, time.Now(), rw.rawFrame)
+		if err == nil {
+			err = rw.Write(r)
+		}
+		if err != nil {
+			ERROR.Println(CLI, "unable to record message:", err)
+			rw.mu.Lock()
+			if rw.err == nil {
+				rw.err = err
+			}
+			rw.mu.Unlock()
+		}
+	}
+}
+
+// Err returns the first error encountered by the handler returned from Handler (nil if none)
+func (rw *RecordWriter) Err() error {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	return rw.err
+}
+
+// RecordReader reads a recording from an io.Reader
+type RecordReader struct {
+	dec  *json.Decoder
+	line int
+}
+
+// NewRecordReader returns a RecordReader that reads from r
+func NewRecordReader(r io.Reader) *RecordReader {
+	return &RecordReader{dec: json.NewDecoder(r)}
+}
+
+// Next returns the next record in the recording; io.EOF is returned when there are no more records
+func (rr *RecordReader) Next() (*Record, error) {
+	var r Record
+	if err := rr.dec.Decode(&r); err != nil {
+		if err == io.EOF {
+			return nil, err
+		}
+		return nil, fmt.Errorf("record %d: %s", rr.line+1, err)
+	}
+	rr.line++
+	if len(r.Frame) > 0 {
+		cp, err := packets.ReadPacket(bytes.NewReader(r.Frame))
+		if err != nil {
+			return nil, fmt.Errorf("record %d: unable to decode frame: %s", rr.line, err)
+		}
+		pub, ok := cp.(*packets.PublishPacket)
+		if !ok {
+			return nil, fmt.Errorf("record %d: frame is not a PUBLISH packet", rr.line)
+		}
+		r.Topic = pub.TopicName
+		r.Qos = pub.Qos
+		r.Retained = pub.Retain
+		r.Duplicate = pub.Dup
+		r.MessageID = pub.MessageID
+		r.Payload = pub.Payload
+	}
+	return &r, nil
+}
+
+// ReplayFunc is called by Replay for each record in a recording
+type ReplayFunc func(*Record) error
+
+// Replay reads all records from rr and passes them to fn. The delay between calls to fn
+// is the delay between the original messages divided by speed; so a speed of 1 replicates the
+// original timing, 2 replays at double speed and 0 replays as fast as possible.
+// Replay stops when the recording ends (returning nil), when fn returns an error or when ctx
+// is cancelled (returning ctx.Err()).
+func Replay(ctx context.Context, rr *RecordReader, speed float64, fn ReplayFunc) error {
+	if speed < 0 {
+		return errors.New("invalid replay speed")
+	}
+	var first time.Time
+	var start time.Time
+	for {
+		r, err := rr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if speed > 0 {
+			if start.IsZero() {
+				first, start = r.Time, time.Now()
+			}
+			due := start.Add(time.Duration(float64(r.Time.Sub(first)) / speed))
+			if wait := time.Until(due); wait > 0 {
+				timer := time.NewTimer(wait)
+				select {
+				case <-ctx.Done():
+					timer.Stop()
+					return ctx.Err()
+				case <-timer.C:
+				}
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+		if err := fn(r); err != nil {
+			return err
+		}
+	}
+}
+
+// ReplayToClient publishes each message in the recording using the client (see Replay for
+// details of speed). Messages are published with their original topic, QoS and retained flag;
+// replay stops if a publish fails.
+func ReplayToClient(ctx context.Context, rr *RecordReader, speed float64, c Client) error {
+	return Replay(ctx, rr, speed, func(r *Record) error {
+		t := c.Publish(r.Topic, r.Qos, r.Retained, r.Payload)
+		select {
+		case <-t.Done():
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		return t.Error()
+	})
+}
+
+// ReplayToHandler passes each message in the recording to the handler (see Replay for details of
+// speed); no broker is involved so this is useful when testing handlers. The client passed in
+// is provided to the handler and may be nil.
+func ReplayToHandler(ctx context.Context, rr *RecordReader, speed float64, c Client, handler MessageHandler) error {
+	return Replay(ctx, rr, speed, func(r *Record) error {
+		handler(c, r.Message())
+		return nil
+	})
+}
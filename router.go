@@ -61,13 +61,23 @@ func routeIncludesTopic(route, topic string) bool {
 // removes $share and sharename when splitting the route to allow
 // shared subscription routes to correctly match the topic
 func routeSplit(route string) []string {
-	var result []string
-	if strings.HasPrefix(route, "$share") {
-		result = strings.Split(route, "/")[2:]
-	} else {
-		result = strings.Split(route, "/")
+	return strings.Split(routeTopic(route), "/")
+}
+
+// routeTopic returns the topic filter that messages received due to a subscription
+// to filter will match. The broker delivers messages from shared subscriptions
+// ($share/{ShareName}/{filter}) and queues ($queue/{filter}) with their original
+// topic so the prefix is removed.
+func routeTopic(filter string) string {
+	switch {
+	case strings.HasPrefix(filter, "$share/"):
+		if parts := strings.SplitN(filter, "/", 3); len(parts) == 3 {
+			return parts[2]
+		}
+	case strings.HasPrefix(filter, "$queue/"):
+		return strings.TrimPrefix(filter, "$queue/")
 	}
-	return result
+	return filter
 }
 
 // match takes the topic string of the published message and does a basic compare to the
@@ -93,7 +103,10 @@ func newRouter() *router {
 // addRoute takes a topic string and MessageHandler callback. It looks in the current list of
 // routes to see if there is already a matching Route. If there is it replaces the current
 // callback with the new one. If not it add a new entry to the list of Routes.
+// Shared subscription and queue prefixes are removed from topic (so subscriptions
+// to the same filter in different share groups share a route).
 func (r *router) addRoute(topic string, callback MessageHandler) {
+	topic = routeTopic(topic)
 	r.Lock()
 	defer r.Unlock()
 	for e := r.routes.Front(); e != nil; e = e.Next() {
@@ -109,6 +122,7 @@ func (r *router) addRoute(topic string, callback MessageHandler) {
 // deleteRoute takes a route string, looks for a matching Route in the list of Routes. If
 // found it removes the Route from the list.
 func (r *router) deleteRoute(topic string) {
+	topic = routeTopic(topic)
 	r.Lock()
 	defer r.Unlock()
 	for e := r.routes.Front(); e != nil; e = e.Next() {
@@ -137,8 +151,13 @@ func (r *router) matchAndDispatch(messages <-chan *packets.PublishPacket, order
 		for message := range messages {
 			// DEBUG.Println(ROU, "matchAndDispatch received message")
 			sent := false
-			r.RLock()
 			m := messageFromPublish(message, ackFunc(ackChan, client.persist, message))
+			if d := client.options.Deduplicator; d != nil && d.Duplicate(m) {
+				DEBUG.Println(ROU, "matchAndDispatch suppressed duplicate message, msgId:", message.MessageID)
+				m.Ack()
+				continue
+			}
+			r.RLock()
 			var handlers []MessageHandler
 			for e := r.routes.Front(); e != nil; e = e.Next() {
 				if e.Value.(*route).match(message.TopicName) {
@@ -0,0 +1,228 @@
+package mqtt
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+)
+
+// Request/response
+//
+// MQTT has no built in request/response mechanism; MQTT v5 adds the Response Topic and Correlation
+// Data properties but this client speaks v3.1/v3.1.1 so these are carried in a JSON envelope within
+// the payload:
+//   {"response_topic":"resp/dev1/7d1e...","correlation_data":"fR4=","payload":"aGVsbG8=","error":""}
+// (binary fields are base64 encoded). A Requester publishes requests in this envelope and routes the
+// replies back to the waiting caller; a Responder decodes requests, calls a handler and publishes
+// the reply to the response topic provided.
+
+// ErrRequesterClosed is returned when a request is made (or outstanding) when the Requester is closed
+var ErrRequesterClosed = errors.New("requester closed")
+
+// ResponseError is returned by Request when the responder reports that the request failed
+type ResponseError string
+
+func (e ResponseError) Error() string {
+	return string(e)
+}
+
+// rpcEnvelope is the payload of requests and responses
+type rpcEnvelope struct {
+	ResponseTopic   string `json:"response_topic,omitempty"`
+	CorrelationData []byte `json:"correlation_data,omitempty"`
+	Payload         []byte `json:"payload"`
+	Error           string `json:"error,omitempty"`
+}
+
+// Requester sends requests and waits for the corresponding responses. A single subscription
+// (responseTopic/+) is shared by all requests; each request is allocated a correlation id which
+// is appended to the response topic and included in the request envelope.
+// A Requester is safe for concurrent use by multiple goroutines.
+type Requester struct {
+	client        Client
+	responseTopic string
+	qos           byte
+
+	mu      sync.Mutex
+	pending map[string]chan *rpcEnvelope
+	closed  bool
+}
+
+// NewRequester subscribes to responses (on responseTopic/+) and returns a Requester that can be
+// used to send requests. Requests and responses are published at the QoS specified.
+// Note: As the subscription is made immediately the client should be connected (if the connection
+// is lost and CleanSession is true then the Requester will need to be recreated in OnConnect).
+func NewRequester(c Client, responseTopic string, qos byte) (*Requester, error) {
+	r := newRequester(c, responseTopic, qos)
+	if t := c.Subscribe(r.responseTopic+"/+", qos, r.handleResponse); t.Wait() && t.Error() != nil {
+		return nil, t.Error()
+	}
+	return r, nil
+}
+
+func newRequester(c Client, responseTopic string, qos byte) *Requester {
+	return &Requester{
+		client:        c,
+		responseTopic: strings.TrimSuffix(responseTopic, "/"),
+		qos:           qos,
+		pending:       make(map[string]chan *rpcEnvelope),
+	}
+}
+
+// Request publishes payload to topic and waits for the response (or for ctx to be done, in which
+// case ctx.Err() is returned). If the responder reports an error then a ResponseError is returned.
+func (r *Requester) Request(ctx context.Context, topic string, payload []byte) ([]byte, error) {
+	corr, err := newCorrelationID()
+	if err != nil {
+		return nil, err
+	}
+	ch := make(chan *rpcEnvelope, 1)
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return nil, ErrRequesterClosed
+	}
+	r.pending[corr] = ch
+	r.mu.Unlock()
+	defer func() {
+		r.mu.Lock()
+		delete(r.pending, corr)
+		r.mu.Unlock()
+	}()
+
+	req, err := json.Marshal(&rpcEnvelope{
+		ResponseTopic:   r.responseTopic + "/" + corr,
+		CorrelationData: []byte(corr),
+		Payload:         payload,
+	})
+	if err != nil {
+		return nil, err
+	}
+	t := r.client.Publish(topic, r.qos, false, req)
+	select {
+	case <-t.Done():
+		if t.Error() != nil {
+			return nil, t.Error()
+		}
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	select {
+	case resp, ok := <-ch:
+		if !ok {
+			return nil, ErrRequesterClosed
+		}
+		if resp.Error != "" {
+			return nil, ResponseError(resp.Error)
+		}
+		return resp.Payload, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// handleResponse routes a response to the waiting request (responses with no matching request,
+// e.g. because the request timed out, are discarded)
+func (r *Requester) handleResponse(_ Client, m Message) {
+	var resp rpcEnvelope
+	if err := json.Unmarshal(m.Payload(), &resp); err != nil {
+		WARN.Println(CLI, "discarding malformed response on", m.Topic(), err)
+		return
+	}
+	corr := string(resp.CorrelationData)
+	if corr == "" {
+		corr = m.Topic()[strings.LastIndex(m.Topic(), "/")+1:]
+	}
+	r.mu.Lock()
+	ch, ok := r.pending[corr]
+	delete(r.pending, corr)
+	r.mu.Unlock()
+	if !ok {
+		DEBUG.Println(CLI, "discarding response with unknown correlation id", corr)
+		return
+	}
+	ch <- &resp // buffered so will not block
+}
+
+// Close unsubscribes from the response topic; any outstanding requests will fail with ErrRequesterClosed
+func (r *Requester) Close() Token {
+	r.mu.Lock()
+	r.closed = true
+	for corr, ch := range r.pending {
+		close(ch)
+		delete(r.pending, corr)
+	}
+	r.mu.Unlock()
+	return r.client.Unsubscribe(r.responseTopic + "/+")
+}
+
+// newCorrelationID returns a random id suitable for use as a topic level
+func newCorrelationID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// RequestHandler processes a request and returns the response payload. If an error is returned then
+// its text is passed to the requester (and Request will return a ResponseError).
+type RequestHandler func(request []byte) ([]byte, error)
+
+// Responder processes requests sent by a Requester (or any client using the same envelope) and
+// publishes the responses.
+type Responder struct {
+	client Client
+	qos    byte
+}
+
+// NewResponder returns a Responder that will publish responses using the client at the QoS specified
+func NewResponder(c Client, qos byte) *Responder {
+	return &Responder{client: c, qos: qos}
+}
+
+// Handle subscribes to topic (which may contain wildcards) and calls h for each request received.
+// The returned token completes when the subscription has been acknowledged.
+func (r *Responder) Handle(topic string, h RequestHandler) Token {
+	return r.client.Subscribe(topic, r.qos, r.Handler(h))
+}
+
+// Handler returns a MessageHandler that decodes requests, calls h and publishes the response. This
+// can be used with AddRoute where the subscription is made separately.
+// h is called in a new goroutine so may block (and make calls to the client).
+func (r *Responder) Handler(h RequestHandler) MessageHandler {
+	return func(_ Client, m Message) {
+		var req rpcEnvelope
+		if err := json.Unmarshal(m.Payload(), &req); err != nil {
+			WARN.Println(CLI, "discarding malformed request on", m.Topic(), err)
+			return
+		}
+		if req.ResponseTopic == "" {
+			WARN.Println(CLI, "discarding request with no response topic on", m.Topic())
+			return
+		}
+		go func() {
+			resp := rpcEnvelope{CorrelationData: req.CorrelationData}
+			var err error
+			if resp.Payload, err = h(req.Payload); err != nil {
+				resp.Error = err.Error()
+				if resp.Error == "" {
+					resp.Error = "request failed"
+				}
+			}
+			b, err := json.Marshal(&resp)
+			if err != nil {
+				ERROR.Println(CLI, "unable to encode response:", err)
+				return
+			}
+			if t := r.client.Publish(req.ResponseTopic, r.qos, false, b); t.Wait() && t.Error() != nil {
+				ERROR.Println(CLI, "unable to publish response:", t.Error())
+			}
+		}()
+	}
+}
@@ -0,0 +1,21 @@
+package mqtt
+
+// SharedSubscription returns the topic filter used to subscribe to filter as a member of the shared subscription
+// group (i.e. $share/{group}/{filter}). Each message matching filter is delivered to only one member of the group.
+func SharedSubscription(group, filter string) string {
+	return "$share/" + group + "/" + filter
+}
+
+// SubscribeShared subscribes each of the clients (a pool of consumers) to filter as members of the shared
+// subscription group so that the broker distributes matching messages between them. callback is called with
+// messages received by any of the clients (so must be safe for concurrent use) and may be nil to use each client's
+// default handler. One token is returned per client (in the same order); an invalid group or filter is reported
+// via every token.
+func SubscribeShared(clients []Client, group, filter string, qos byte, callback MessageHandler) []Token {
+	topic := SharedSubscription(group, filter)
+	tokens := make([]Token, len(clients))
+	for i, c := range clients {
+		tokens[i] = c.Subscribe(topic, qos, callback)
+	}
+	return tokens
+}
@@ -0,0 +1,88 @@
+package mqtt
+
+import (
+	"crypto/tls"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// TLSOverride holds TLS settings that apply to a single broker, overriding those in the TLS configuration.
+// This allows, for example, ALPN to be used when connecting to MQTT brokers on port 443 or a server name
+// (SNI) that differs from the host being dialled.
+//
+// Overrides can be set with ClientOptions.SetTLSOverride or in the query string of the broker URL:
+//
+//	alpn      - comma separated list of ALPN protocols (e.g. alpn=x-amzn-mqtt-ca)
+//	sni       - server name to send (and verify the certificate against)
+//	insecure  - true to skip verification of the broker certificate, false to require it
+//
+// e.g. "ssl://example.com:443?alpn=mqtt&sni=broker.example.com". These parameters are removed from the URL
+// before it is used (so are not sent in the websocket request). Values in the URL take precedence.
+type TLSOverride struct {
+	NextProtos         []string // ALPN protocols
+	ServerName         string   // SNI
+	InsecureSkipVerify *bool    // if not nil, replaces tls.Config.InsecureSkipVerify
+}
+
+// tlsOverrideParams are the URL query parameters used to set a TLSOverride
+var tlsOverrideParams = []string{"alpn", "sni", "insecure"}
+
+// tlsOverrideFor returns the override for broker (combining those held in overrides with those in the URL)
+func tlsOverrideFor(broker *url.URL, overrides map[string]TLSOverride) TLSOverride {
+	o := overrides[broker.Host]
+	q := broker.Query()
+	if alpn := q.Get("alpn"); alpn != "" {
+		o.NextProtos = strings.Split(alpn, ",")
+	}
+	if sni := q.Get("sni"); sni != "" {
+		o.ServerName = sni
+	}
+	if insecure, err := strconv.ParseBool(q.Get("insecure")); err == nil {
+		o.InsecureSkipVerify = &insecure
+	}
+	return o
+}
+
+// apply returns a copy of c with the overrides applied (c is returned unchanged if there are no overrides)
+func (o TLSOverride) apply(c *tls.Config) *tls.Config {
+	if len(o.NextProtos) == 0 && o.ServerName == "" && o.InsecureSkipVerify == nil {
+		return c
+	}
+	if c == nil {
+		c = &tls.Config{}
+	} else {
+		c = c.Clone()
+	}
+	if len(o.NextProtos) > 0 {
+		c.NextProtos = o.NextProtos
+	}
+	if o.ServerName != "" {
+		c.ServerName = o.ServerName
+	}
+	if o.InsecureSkipVerify != nil {
+		c.InsecureSkipVerify = *o.InsecureSkipVerify
+	}
+	return c
+}
+
+// withoutTLSOverrides returns broker with the TLS override query parameters removed
+func withoutTLSOverrides(broker *url.URL) *url.URL {
+	if broker.RawQuery == "" {
+		return broker
+	}
+	q := broker.Query()
+	found := false
+	for _, p := range tlsOverrideParams {
+		if _, ok := q[p]; ok {
+			q.Del(p)
+			found = true
+		}
+	}
+	if !found {
+		return broker
+	}
+	u := *broker
+	u.RawQuery = q.Encode()
+	return &u
+}
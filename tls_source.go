@@ -0,0 +1,182 @@
+package mqtt
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+// TLSConfigProvider returns the TLS configuration to be used for a new connection. It is called every
+// time a TLS (or secure websocket) connection is opened so can be used to supply rotated certificates.
+type TLSConfigProvider func() (*tls.Config, error)
+
+// isTLSScheme returns true if connections using the scheme are secured with TLS
+func isTLSScheme(scheme string) bool {
+	switch scheme {
+	case "ssl", "tls", "mqtts", "mqtt+ssl", "tcps", "wss":
+		return true
+	}
+	return false
+}
+
+// TLSFileSource loads a client certificate, key and (optionally) CA certificates from PEM encoded files
+// and reloads them whenever the files change. Its Config method can be passed to
+// ClientOptions.SetTLSConfigProvider so that every connection uses the current files.
+// If the files cannot be loaded (e.g. they are part way through being replaced) the previously loaded
+// configuration is used.
+type TLSFileSource struct {
+	certFile, keyFile, caFile string
+	base                      *tls.Config
+
+	mu      sync.Mutex
+	config  *tls.Config
+	modTime map[string]time.Time // modification times of the files when last loaded
+}
+
+// NewTLSFileSource creates a TLSFileSource. caFile may be empty (in which case base.RootCAs, or the system
+// pool, is used). The returned configurations are copies of base (which may be nil) with the certificate
+// and CA pool set.
+func NewTLSFileSource(certFile, keyFile, caFile string, base *tls.Config) *TLSFileSource {
+	return &TLSFileSource{certFile: certFile, keyFile: keyFile, caFile: caFile, base: base}
+}
+
+// Config returns the TLS configuration, reloading the files if they have changed since they were last read
+func (s *TLSFileSource) Config() (*tls.Config, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	modTime, err := s.modTimes()
+	if err == nil && s.config != nil && sameModTimes(modTime, s.modTime) {
+		return s.config, nil
+	}
+	var config *tls.Config
+	if err == nil {
+		config, err = s.load()
+	}
+	if err != nil {
+		if s.config == nil {
+			return nil, err
+		}
+		WARN.Println(CLI, "unable to reload TLS files (using previous configuration):", err)
+		return s.config, nil
+	}
+	DEBUG.Println(CLI, "loaded TLS certificate from", s.certFile)
+	s.config = config
+	s.modTime = modTime
+	return config, nil
+}
+
+// modTimes returns the modification time of each file
+func (s *TLSFileSource) modTimes() (map[string]time.Time, error) {
+	m := make(map[string]time.Time, 3)
+	for _, f := range []string{s.certFile, s.keyFile, s.caFile} {
+		if f == "" {
+			continue
+		}
+		fi, err := os.Stat(f)
+		if err != nil {
+			return nil, err
+		}
+		m[f] = fi.ModTime()
+	}
+	return m, nil
+}
+
+func sameModTimes(a, b map[string]time.Time) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if !b[k].Equal(v) {
+			return false
+		}
+	}
+	return true
+}
+
+// load reads the files and builds a new configuration
+func (s *TLSFileSource) load() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(s.certFile, s.keyFile)
+	if err != nil {
+		return nil, err
+	}
+	if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+		return nil, err
+	}
+	config := &tls.Config{}
+	if s.base != nil {
+		config = s.base.Clone()
+	}
+	config.Certificates = []tls.Certificate{cert}
+	if s.caFile != "" {
+		pem, err := ioutil.ReadFile(s.caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", s.caFile)
+		}
+		config.RootCAs = pool
+	}
+	return config, nil
+}
+
+// ErrCertificateExpired is returned when the client certificate supplied by the TLSConfigProvider has
+// expired (or is not yet valid)
+var ErrCertificateExpired = errors.New("client certificate has expired or is not yet valid")
+
+// tlsConfig returns the TLS configuration to use when connecting to broker (nil if TLS is not used) with
+// any TLSOverride applied. The client certificate is checked and an EventCertificateExpiring is emitted
+// if it expires within TLSExpiryWarning. A certificate that is not currently valid is rejected if it was
+// obtained from the TLSConfigProvider; a static TLSConfig is used regardless (with a warning) as it may
+// deliberately present an unusual certificate.
+func (c *client) tlsConfig(broker *url.URL) (*tls.Config, error) {
+	c.optionsMu.Lock()
+	tlsc := c.options.TLSConfig
+	provider := c.options.TLSConfigProvider
+	override := tlsOverrideFor(broker, c.options.TLSOverrides)
+	c.optionsMu.Unlock()
+	if !isTLSScheme(broker.Scheme) {
+		return tlsc, nil // retains previous behaviour (the config is passed through but not used)
+	}
+	if provider != nil {
+		var err error
+		if tlsc, err = provider(); err != nil {
+			return nil, fmt.Errorf("unable to obtain TLS configuration: %s", err)
+		}
+	}
+	tlsc = override.apply(tlsc)
+	if tlsc == nil || len(tlsc.Certificates) == 0 || len(tlsc.Certificates[0].Certificate) == 0 {
+		return tlsc, nil
+	}
+	leaf := tlsc.Certificates[0].Leaf
+	if leaf == nil {
+		var err error
+		if leaf, err = x509.ParseCertificate(tlsc.Certificates[0].Certificate[0]); err != nil {
+			if provider == nil {
+				WARN.Println(CLI, "unable to parse client certificate:", err)
+				return tlsc, nil
+			}
+			return nil, fmt.Errorf("unable to parse client certificate: %s", err)
+		}
+	}
+	now := time.Now()
+	if now.After(leaf.NotAfter) || now.Before(leaf.NotBefore) {
+		if provider != nil {
+			return nil, fmt.Errorf("%w (valid from %s to %s)", ErrCertificateExpired, leaf.NotBefore, leaf.NotAfter)
+		}
+		WARN.Println(CLI, "client certificate is only valid from", leaf.NotBefore, "to", leaf.NotAfter)
+	}
+	if c.options.TLSExpiryWarning > 0 && leaf.NotAfter.Sub(now) < c.options.TLSExpiryWarning {
+		WARN.Println(CLI, "client certificate expires at", leaf.NotAfter)
+		c.emitEvent(ConnectionEvent{Type: EventCertificateExpiring, Broker: broker, CertificateExpiry: leaf.NotAfter})
+	}
+	return tlsc, nil
+}
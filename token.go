@@ -13,6 +13,7 @@
 package mqtt
 
 import (
+	"crypto/tls"
 	"sync"
 	"time"
 
@@ -137,6 +138,7 @@ type ConnectToken struct {
 	baseToken
 	returnCode     byte
 	sessionPresent bool
+	tlsState       *tls.ConnectionState
 }
 
 // ReturnCode returns the acknowledgement code in the connack sent
@@ -155,6 +157,15 @@ func (c *ConnectToken) SessionPresent() bool {
 	return c.sessionPresent
 }
 
+// TLSConnectionState returns the state of the TLS connection (protocol version, cipher suite, negotiated
+// protocol, peer certificates etc) established by Connect for ssl:// and wss:// brokers; nil if
+// TLS was not used (or the connection failed)
+func (c *ConnectToken) TLSConnectionState() *tls.ConnectionState {
+	c.m.RLock()
+	defer c.m.RUnlock()
+	return c.tlsState
+}
+
 // PublishToken is an extension of Token containing the extra fields
 // required to provide information about calls to Publish()
 type PublishToken struct {
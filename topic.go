@@ -17,6 +17,7 @@ package mqtt
 import (
 	"errors"
 	"strings"
+	"unicode/utf8"
 )
 
 // ErrInvalidQos is the error returned when an packet is to be sent
@@ -32,6 +33,30 @@ var ErrInvalidTopicEmptyString = errors.New("invalid Topic; empty string")
 // the last
 var ErrInvalidTopicMultilevel = errors.New("invalid Topic; multi-level wildcard must be last level")
 
+// ErrInvalidTopicWildcard is the error returned when a topic filter
+// contains a wildcard that does not occupy an entire level (e.g. "a+/b")
+var ErrInvalidTopicWildcard = errors.New("invalid Topic; wildcard must occupy an entire level")
+
+// ErrInvalidTopicNameWildcard is the error returned when a topic name
+// (i.e. a topic published to) contains a wildcard
+var ErrInvalidTopicNameWildcard = errors.New("invalid Topic; wildcards are not permitted in topic names")
+
+// ErrInvalidTopicTooLong is the error returned when a topic is longer
+// than 65535 bytes
+var ErrInvalidTopicTooLong = errors.New("invalid Topic; longer than 65535 bytes")
+
+// ErrInvalidTopicUTF8 is the error returned when a topic is not valid
+// UTF-8 or contains the null character (U+0000)
+var ErrInvalidTopicUTF8 = errors.New("invalid Topic; malformed UTF-8 or null character")
+
+// ErrInvalidSharedSubscription is the error returned when a topic
+// filter starting "$share/" is not of the form $share/{ShareName}/{filter}
+// (ShareName must be at least one character and may not contain "/", "+" or "#")
+var ErrInvalidSharedSubscription = errors.New("invalid Topic; malformed shared subscription")
+
+// maxTopicLength is the maximum length, in bytes, of a topic (the length is encoded as a uint16)
+const maxTopicLength = 65535
+
 // Topic Names and Topic Filters
 // The MQTT v3.1.1 spec clarifies a number of ambiguities with regard
 // to the validity of Topic strings.
@@ -68,19 +93,77 @@ func validateSubscribeMap(subs map[string]byte) ([]string, []byte, error) {
 }
 
 func validateTopicAndQos(topic string, qos byte) error {
-	if len(topic) == 0 {
-		return ErrInvalidTopicEmptyString
+	if err := ValidateTopicFilter(topic); err != nil {
+		return err
+	}
+	if qos > 2 {
+		return ErrInvalidQos
+	}
+	return nil
+}
+
+// ValidateTopicName checks that topic is a valid MQTT topic name (i.e. one
+// that can be published to). It must be between 1 and 65535 bytes of UTF-8
+// not containing U+0000 and may not contain the wildcards "+" or "#".
+func ValidateTopicName(topic string) error {
+	if err := validateTopicString(topic); err != nil {
+		return err
+	}
+	if strings.ContainsAny(topic, "+#") {
+		return ErrInvalidTopicNameWildcard
+	}
+	return nil
+}
+
+// ValidateTopicFilter checks that filter is a valid MQTT topic filter (i.e.
+// one that can be subscribed to). In addition to the rules for topic names
+// wildcards are permitted, but must occupy an entire level, and "#" may only
+// be the last level. Shared subscriptions ($share/{ShareName}/{filter}) are
+// validated as such.
+func ValidateTopicFilter(filter string) error {
+	if err := validateTopicString(filter); err != nil {
+		return err
+	}
+	if strings.HasPrefix(filter, "$share/") {
+		parts := strings.SplitN(filter, "/", 3)
+		if len(parts) != 3 || len(parts[1]) == 0 || len(parts[2]) == 0 || strings.ContainsAny(parts[1], "+#") {
+			return ErrInvalidSharedSubscription
+		}
+		filter = parts[2]
 	}
 
-	levels := strings.Split(topic, "/")
+	levels := strings.Split(filter, "/")
 	for i, level := range levels {
+		if len(level) > 1 && strings.ContainsAny(level, "+#") {
+			return ErrInvalidTopicWildcard
+		}
 		if level == "#" && i != len(levels)-1 {
 			return ErrInvalidTopicMultilevel
 		}
 	}
+	return nil
+}
 
+// validateWill checks the will topic and QoS (the will is published to the topic so it must be a valid topic name)
+func validateWill(topic string, qos byte) error {
+	if err := ValidateTopicName(topic); err != nil {
+		return err
+	}
 	if qos > 2 {
 		return ErrInvalidQos
 	}
 	return nil
 }
+
+// validateTopicString applies the rules common to topic names and filters
+func validateTopicString(topic string) error {
+	switch {
+	case len(topic) == 0:
+		return ErrInvalidTopicEmptyString
+	case len(topic) > maxTopicLength:
+		return ErrInvalidTopicTooLong
+	case !utf8.ValidString(topic) || strings.IndexByte(topic, 0) >= 0:
+		return ErrInvalidTopicUTF8
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+package mqtt
+
+import (
+	"math"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+func Test_BackoffStrategies(t *testing.T) {
+	exp := ExponentialJitterBackoff(100*time.Millisecond, time.Second)
+	dec := DecorrelatedJitterBackoff(100*time.Millisecond, time.Second)
+	var prev time.Duration
+	for attempt := 1; attempt < 100; attempt++ {
+		limit := time.Second
+		if attempt < 5 {
+			limit = 100 * time.Millisecond << uint(attempt-1)
+		}
+		if d := exp.Delay(BackoffState{Attempt: attempt}); d < 0 || d > limit {
+			t.Fatalf("exponential delay %s for attempt %d outside [0, %s]", d, attempt, limit)
+		}
+		d := dec.Delay(BackoffState{Attempt: attempt, Previous: prev})
+		if d < 100*time.Millisecond || d > time.Second {
+			t.Fatalf("decorrelated delay %s for attempt %d out of range", d, attempt)
+		}
+		prev = d
+	}
+	// Once the limit reaches max it must stay there however many attempts are made (base << attempt overflows)
+	for _, tc := range []struct {
+		base, max time.Duration
+		attempts  []int
+	}{
+		{100 * time.Millisecond, time.Second, []int{5, 37, 40, 63, 64, 70, 1000}},
+		{9, math.MaxInt64, []int{60, 61, 62, 63, 64, 70, 1000}},
+	} {
+		exp := ExponentialJitterBackoff(tc.base, tc.max)
+		for _, attempt := range tc.attempts {
+			var longest time.Duration
+			for i := 0; i < 50; i++ {
+				if d := exp.Delay(BackoffState{Attempt: attempt}); d > longest {
+					longest = d
+				}
+			}
+			if longest < tc.max/2 || longest > tc.max {
+				t.Fatalf("exponential delays (base %d, max %d) for attempt %d not spread up to max (longest %d)", tc.base, tc.max, attempt, longest)
+			}
+		}
+	}
+	if d := ConstantBackoff(time.Minute).Delay(BackoffState{Attempt: 20}); d != time.Minute {
+		t.Fatalf("unexpected constant delay %s", d)
+	}
+
+	o := NewClientOptions().SetMaxReconnectInterval(5 * time.Second).SetConnectRetryInterval(3 * time.Second)
+	def := defaultBackoff(o)
+	if d := def.Delay(BackoffState{Attempt: 1}); d != 3*time.Second {
+		t.Fatalf("default connect retry delay %s", d)
+	}
+	prev = 0
+	for _, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		prev = def.Delay(BackoffState{Reconnect: true, Previous: prev})
+		if prev != want {
+			t.Fatalf("default reconnect delay %s, expected %s", prev, want)
+		}
+	}
+}
+
+func Test_ConnectRetryBackoffCancel(t *testing.T) {
+	// Find a port with nothing listening on it
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to listen: %s", err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	states := make(chan BackoffState, 10)
+	ops := NewClientOptions().AddBroker("tcp://" + addr).SetConnectRetry(true).
+		SetBackoffStrategy(BackoffFunc(func(s BackoffState) time.Duration {
+			states <- s
+			if s.Attempt < 3 {
+				return time.Millisecond
+			}
+			return time.Hour
+		}))
+	c := NewClient(ops)
+	token := c.Connect()
+
+	for attempt := 1; attempt <= 3; attempt++ {
+		select {
+		case s := <-states:
+			if s.Attempt != attempt || s.Err == nil || s.Reconnect || s.Broker == nil || s.Broker.Host != addr {
+				t.Fatalf("unexpected backoff state %+v", s)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("backoff strategy not called for attempt %d", attempt)
+		}
+	}
+
+	// The client is now waiting for an hour; Disconnect should cancel that
+	c.Disconnect(0)
+	if !token.WaitTimeout(5 * time.Second) {
+		t.Fatalf("connect retry was not cancelled")
+	}
+	if token.Error() == nil {
+		t.Fatalf("expected an error from the connect token")
+	}
+}
+
+func Test_ReconnectBackoffDisconnect(t *testing.T) {
+	// The broker accepts the first connection then closes it; later connections are closed immediately
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to listen: %s", err)
+	}
+	defer l.Close()
+	go func() {
+		for n := 0; ; n++ {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			if n == 0 {
+				if _, err = packets.ReadPacket(conn); err == nil {
+					packets.NewControlPacket(packets.Connack).Write(conn)
+					time.Sleep(50 * time.Millisecond)
+				}
+			}
+			conn.Close()
+		}
+	}()
+
+	states := make(chan BackoffState, 10)
+	ops := NewClientOptions().AddBroker("tcp://" + l.Addr().String()).SetKeepAlive(0).SetWriteTimeout(time.Second).
+		SetAutoReconnect(true).SetBackoffStrategy(BackoffFunc(func(s BackoffState) time.Duration {
+		states <- s
+		return time.Hour
+	}))
+	c := NewClient(ops)
+	if token := c.Connect(); !token.WaitTimeout(5*time.Second) || token.Error() != nil {
+		t.Fatalf("connect failed: %v", token.Error())
+	}
+	select {
+	case s := <-states:
+		if !s.Reconnect {
+			t.Fatalf("unexpected backoff state %+v", s)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("client did not attempt to reconnect")
+	}
+
+	// The client is now waiting for an hour. Cancel the wait without changing the status (as happens if
+	// the retry is cancelled before Disconnect updates the status); the reconnection must be abandoned
+	// without starting the comms workers on a nil connection.
+	cl := c.(*client)
+	cl.cancelRetry()
+	time.Sleep(100 * time.Millisecond)
+	cl.connMu.Lock()
+	conn := cl.conn
+	cl.connMu.Unlock()
+	if conn != nil {
+		t.Fatal("comms workers started after the retry was cancelled")
+	}
+
+	c.Disconnect(0)
+	if c.IsConnected() || cl.connectionStatus() != disconnected {
+		t.Fatalf("client not disconnected (status %d)", cl.connectionStatus())
+	}
+}
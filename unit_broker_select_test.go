@@ -0,0 +1,231 @@
+package mqtt
+
+import (
+	"errors"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+// testBroker is a minimal broker that accepts connections and discards anything received after CONNECT
+type testBroker struct {
+	l        net.Listener
+	connects chan *packets.ConnectPacket          // CONNECT packets received
+	connack  func(cm *packets.ConnectPacket) byte // return code to send in response to CONNECT
+	scheme   string                               // scheme used in the URL (tcp if empty)
+}
+
+func newTestBroker(t *testing.T) *testBroker {
+	return newTestBrokerWithConnack(t, func(*packets.ConnectPacket) byte { return packets.Accepted })
+}
+
+func newTestBrokerWithConnack(t *testing.T, connack func(cm *packets.ConnectPacket) byte) *testBroker {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to listen: %s", err)
+	}
+	return startTestBroker(l, connack)
+}
+
+// startTestBroker accepts connections on l
+func startTestBroker(l net.Listener, connack func(cm *packets.ConnectPacket) byte) *testBroker {
+	b := &testBroker{l: l, connects: make(chan *packets.ConnectPacket, 10), connack: connack}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go b.serve(conn)
+		}
+	}()
+	return b
+}
+
+func (b *testBroker) serve(conn net.Conn) {
+	defer conn.Close()
+	cp, err := packets.ReadPacket(conn)
+	if err != nil {
+		return
+	}
+	cm, ok := cp.(*packets.ConnectPacket)
+	if !ok {
+		return
+	}
+	select {
+	case b.connects <- cm:
+	default:
+	}
+	ca := packets.NewControlPacket(packets.Connack).(*packets.ConnackPacket)
+	ca.ReturnCode = b.connack(cm)
+	if err := ca.Write(conn); err != nil || ca.ReturnCode != packets.Accepted {
+		return
+	}
+	for {
+		cp, err := packets.ReadPacket(conn)
+		if err != nil {
+			return
+		}
+		if _, ok := cp.(*packets.DisconnectPacket); ok {
+			return
+		}
+	}
+}
+
+func (b *testBroker) URL() string {
+	if b.scheme != "" {
+		return b.scheme + "://" + b.l.Addr().String()
+	}
+	return "tcp://" + b.l.Addr().String()
+}
+
+func (b *testBroker) Close() {
+	b.l.Close()
+}
+
+// closedPortURL returns a URL for a port that nothing is listening on
+func closedPortURL(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to listen: %s", err)
+	}
+	defer l.Close()
+	return "tcp://" + l.Addr().String()
+}
+
+func testBrokerURLs(s ...string) []*url.URL {
+	u := make([]*url.URL, len(s))
+	for i := range s {
+		u[i], _ = url.Parse(s[i])
+	}
+	return u
+}
+
+func brokerHosts(b []*url.URL) string {
+	var s string
+	for _, u := range b {
+		s += u.Host
+	}
+	return s
+}
+
+func Test_BrokerSelectors(t *testing.T) {
+	brokers := testBrokerURLs("tcp://a", "tcp://b", "tcp://c")
+
+	if o := brokerHosts(OrderedSelector().Order(brokers)); o != "abc" {
+		t.Fatalf("ordered selector returned %s", o)
+	}
+
+	rr := RoundRobinSelector()
+	for _, want := range []string{"abc", "bca", "cab", "abc"} {
+		if o := brokerHosts(rr.Order(brokers)); o != want {
+			t.Fatalf("round robin selector returned %s, expected %s", o, want)
+		}
+	}
+
+	if o := RandomSelector().Order(brokers); len(o) != 3 || brokerHosts(brokers) != "abc" {
+		t.Fatalf("random selector returned %d brokers or modified input", len(o))
+	}
+
+	sticky := StickySelector()
+	sticky.Result(brokers[2], time.Millisecond, nil)
+	sticky.Result(brokers[0], time.Millisecond, errors.New("failed"))
+	if o := brokerHosts(sticky.Order(brokers)); o != "cab" {
+		t.Fatalf("sticky selector returned %s", o)
+	}
+
+	h := NewHealthSelector(nil, time.Minute, 10*time.Millisecond)
+	h.Result(brokers[0], time.Millisecond, errors.New("failed"))
+	h.Result(brokers[1], 50*time.Millisecond, nil)
+	h.Result(brokers[2], 2*time.Millisecond, nil)
+	if o := brokerHosts(h.Order(brokers)); o != "cba" {
+		t.Fatalf("health selector returned %s", o)
+	}
+	if hl := h.Health(brokers[0]); hl.RecentFailures != 1 || hl.LastError == nil {
+		t.Fatalf("unexpected health %+v", hl)
+	}
+
+	// Once the failure has expired latency should be ignored if within the tolerance
+	h = NewHealthSelector(nil, 0, 10*time.Millisecond)
+	h.Result(brokers[0], time.Millisecond, errors.New("failed"))
+	h.Result(brokers[1], 5*time.Millisecond, nil)
+	h.Result(brokers[2], 2*time.Millisecond, nil)
+	time.Sleep(time.Millisecond)
+	if o := brokerHosts(h.Order(brokers)); o != "abc" {
+		t.Fatalf("health selector returned %s", o)
+	}
+}
+
+func Test_SRVResolver(t *testing.T) {
+	r := &SRVResolver{LookupSRV: func(service, proto, name string) (string, []*net.SRV, error) {
+		if proto != "tcp" || name != "example.com" {
+			return "", nil, errors.New("not found")
+		}
+		if service == "secure-mqtt" {
+			return "", []*net.SRV{{Target: "s1.example.com.", Port: 8883}}, nil
+		}
+		return "", []*net.SRV{{Target: "b1.example.com.", Port: 1883}, {Target: "b2.example.com.", Port: 1884}}, nil
+	}}
+
+	brokers, err := resolveServers(r, testBrokerURLs("tcp+srv://example.com", "ssl+srv://example.com", "ws://other:80/mqtt"))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var got []string
+	for _, b := range brokers {
+		got = append(got, b.String())
+	}
+	want := []string{"tcp://b1.example.com:1883", "tcp://b2.example.com:1884", "ssl://s1.example.com:8883", "ws://other:80/mqtt"}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, expected %v", got, want)
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Fatalf("got %v, expected %v", got, want)
+		}
+	}
+
+	if _, err := resolveServers(r, testBrokerURLs("tcp+srv://unknown.com")); err == nil {
+		t.Fatalf("expected an error when nothing resolves")
+	}
+}
+
+func Test_ConnectBrokerSelection(t *testing.T) {
+	broker := newTestBroker(t)
+	defer broker.Close()
+	bad := closedPortURL(t)
+
+	onConnect := make(chan *url.URL, 1)
+	selector := NewHealthSelector(nil, time.Minute, 0)
+	ops := NewClientOptions().AddBroker(bad).AddBroker(broker.URL()).SetBrokerSelector(selector).
+		SetKeepAlive(0).SetWriteTimeout(time.Second).SetAutoReconnect(false).
+		SetOnConnectHandler(func(c Client) { onConnect <- c.(BrokerReporter).ConnectedBroker() })
+	c := NewClient(ops)
+
+	for i := 0; i < 2; i++ {
+		if token := c.Connect(); !token.WaitTimeout(5*time.Second) || token.Error() != nil {
+			t.Fatalf("connect failed: %v", token.Error())
+		}
+		select {
+		case b := <-onConnect:
+			if b == nil || b.String() != broker.URL() {
+				t.Fatalf("OnConnect saw broker %v", b)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("OnConnect not called")
+		}
+		if b := c.(BrokerReporter).ConnectedBroker(); b == nil || b.String() != broker.URL() {
+			t.Fatalf("connected to %v, expected %s", b, broker.URL())
+		}
+		c.Disconnect(10)
+	}
+
+	// The failed broker should have been tried once (it was moved to the end of the list after failing)
+	badURL, _ := url.Parse(bad)
+	if h := selector.Health(badURL); h.RecentFailures != 1 {
+		t.Fatalf("expected one failure, got %+v", h)
+	}
+}
@@ -15,11 +15,18 @@
 package mqtt
 
 import (
+	"errors"
 	"log"
+	"net"
 	"net/http"
 	_ "net/http/pprof"
+	"net/url"
 	"os"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
 )
 
 func init() {
@@ -104,3 +111,174 @@ func Test_isConnectionOpenNegative(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func Test_UpdateOptionsAndReconnect(t *testing.T) {
+	b1 := newTestBroker(t)
+	defer b1.Close()
+	b2 := newTestBroker(t)
+	defer b2.Close()
+
+	ops := NewClientOptions().AddBroker(b1.URL()).SetUsername("user").SetPassword("old").
+		SetKeepAlive(0).SetWriteTimeout(time.Second).SetAutoReconnect(false)
+	c := NewClient(ops)
+	if token := c.Connect(); !token.WaitTimeout(5*time.Second) || token.Error() != nil {
+		t.Fatalf("connect failed: %v", token.Error())
+	}
+	if cm := <-b1.connects; string(cm.Password) != "old" {
+		t.Fatalf("unexpected password %q", cm.Password)
+	}
+
+	// Publish a message that will be in flight (the test broker never acknowledges it)
+	c.Publish("test", 1, false, []byte("hello"))
+
+	r, ok := c.(Reconfigurer)
+	if !ok {
+		t.Fatal("client does not implement Reconfigurer")
+	}
+	u, _ := url.Parse(b2.URL())
+	r.UpdateServers([]*url.URL{u})
+	r.UpdateCredentials("user", "new")
+	if r := c.OptionsReader(); r.Password() != "new" || len(r.Servers()) != 1 || r.Servers()[0].String() != b2.URL() {
+		t.Fatalf("options not updated")
+	}
+	r.Reconnect(100)
+
+	select {
+	case cm := <-b2.connects:
+		if string(cm.Password) != "new" {
+			t.Fatalf("unexpected password %q", cm.Password)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("client did not reconnect")
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for !c.IsConnectionOpen() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if b := c.(BrokerReporter).ConnectedBroker(); b == nil || b.String() != b2.URL() {
+		t.Fatalf("connected to %v, expected %s", b, b2.URL())
+	}
+	// The message should have been retained and resent (the broker never acknowledges it)
+	if keys := c.(*client).persist.All(); len(keys) != 1 || !isKeyOutbound(keys[0]) {
+		t.Fatalf("in flight message was not retained: %v", keys)
+	}
+	c.Disconnect(10)
+}
+
+// disconnectHoldConn delays the completion of a DISCONNECT write until the connection has been closed (so that
+// the EOF from a broker that closes the connection upon receiving the DISCONNECT is processed first)
+type disconnectHoldConn struct {
+	net.Conn
+	closed chan struct{}
+	once   sync.Once
+}
+
+func (c *disconnectHoldConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	if err == nil && len(b) > 0 && b[0] == packets.Disconnect<<4 {
+		select {
+		case <-c.closed:
+		case <-time.After(5 * time.Second):
+		}
+	}
+	return n, err
+}
+
+func (c *disconnectHoldConn) Close() error {
+	c.once.Do(func() { close(c.closed) })
+	return c.Conn.Close()
+}
+
+func Test_ReconnectBrokerClosesFirst(t *testing.T) {
+	broker := newTestBroker(t) // closes the connection when DISCONNECT is received
+	defer broker.Close()
+
+	lost := make(chan error, 1)
+	ops := NewClientOptions().AddBroker(broker.URL()).SetKeepAlive(0).SetWriteTimeout(time.Second).
+		SetAutoReconnect(false).SetConnectionLostHandler(func(_ Client, err error) { lost <- err })
+	c := NewClient(ops)
+	c.SetCustomCallback(func() (net.Conn, error) {
+		conn, err := net.Dial("tcp", broker.l.Addr().String())
+		if err != nil {
+			return nil, err
+		}
+		return &disconnectHoldConn{Conn: conn, closed: make(chan struct{})}, nil
+	})
+	if token := c.Connect(); !token.WaitTimeout(5*time.Second) || token.Error() != nil {
+		t.Fatalf("connect failed: %v", token.Error())
+	}
+	<-broker.connects
+
+	c.(Reconfigurer).Reconnect(1000)
+	select {
+	case <-broker.connects:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("client did not reconnect")
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for !c.IsConnectionOpen() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !c.IsConnectionOpen() {
+		t.Fatalf("connection not open after Reconnect")
+	}
+	select {
+	case err := <-lost:
+		t.Fatalf("OnConnectionLost called during Reconnect: %v", err)
+	default:
+	}
+	c.Disconnect(10)
+}
+
+func Test_MalformedPacketClosesConnection(t *testing.T) {
+	tests := []struct {
+		name   string
+		packet []byte
+		ops    func(o *ClientOptions)
+		err    error
+	}{
+		{"too large", []byte{0x30, 0xFF, 0xFF, 0xFF, 0x7F}, func(o *ClientOptions) { o.SetMaxIncomingPacketSize(1024) }, packets.ErrPacketTooLarge},
+		{"invalid UTF-8", []byte{0x30, 0x04, 0x00, 0x02, 0xC3, 0x28}, func(o *ClientOptions) { o.SetStrictDecoding(true) }, packets.ErrInvalidUTF8},
+	}
+	for _, tt := range tests {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("unable to listen: %s", err)
+		}
+		go func(packet []byte) {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			if _, err := packets.ReadPacket(conn); err != nil {
+				return
+			}
+			ca := packets.NewControlPacket(packets.Connack).(*packets.ConnackPacket)
+			if err := ca.Write(conn); err != nil {
+				return
+			}
+			conn.Write(packet)
+			packets.ReadPacket(conn) // wait for the client to close the connection
+		}(tt.packet)
+
+		lost := make(chan error, 1)
+		ops := NewClientOptions().AddBroker("tcp://" + l.Addr().String()).SetKeepAlive(0).SetWriteTimeout(time.Second).
+			SetAutoReconnect(false).SetConnectionLostHandler(func(_ Client, err error) { lost <- err })
+		tt.ops(ops)
+		c := NewClient(ops)
+		if token := c.Connect(); !token.WaitTimeout(5*time.Second) || token.Error() != nil {
+			t.Fatalf("%s: connect failed: %v", tt.name, token.Error())
+		}
+		select {
+		case err := <-lost:
+			var de *packets.DecodeError
+			if !errors.As(err, &de) || !errors.Is(err, tt.err) || de.PacketType != packets.Publish {
+				t.Fatalf("%s: unexpected error %v", tt.name, err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: connection not closed", tt.name)
+		}
+		l.Close()
+	}
+}
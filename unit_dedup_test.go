@@ -0,0 +1,127 @@
+package mqtt
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+func newTestMessage(topic string, qos byte, id uint16, payload string) Message {
+	pub := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+	pub.TopicName = topic
+	pub.Qos = qos
+	pub.MessageID = id
+	pub.Payload = []byte(payload)
+	return messageFromPublish(pub, func() {})
+}
+
+func Test_DedupKeys(t *testing.T) {
+	if DedupByMessageID(newTestMessage("a", 0, 0, "x")) != "" {
+		t.Fatalf("QoS 0 messages should not be deduplicated by message id")
+	}
+	if DedupByMessageID(newTestMessage("a", 1, 5, "x")) == DedupByMessageID(newTestMessage("b", 1, 5, "x")) {
+		t.Fatalf("message id key should include topic")
+	}
+	if DedupByPayloadHash(newTestMessage("a", 1, 5, "x")) != DedupByPayloadHash(newTestMessage("a", 0, 9, "x")) {
+		t.Fatalf("payload hash key should ignore message id and qos")
+	}
+	prefix := DedupByPayloadPrefix(4)
+	if prefix(newTestMessage("a", 0, 0, "abc")) != "" {
+		t.Fatalf("short payloads should not be deduplicated")
+	}
+	if prefix(newTestMessage("a", 0, 0, "id01rest")) != prefix(newTestMessage("a", 0, 0, "id01other")) {
+		t.Fatalf("payload prefix key should only consider the prefix")
+	}
+}
+
+func Test_DedupWindow(t *testing.T) {
+	d := NewDeduplicator(DedupByPayloadHash, 0, 2)
+	for _, p := range []string{"a", "b", "a", "c", "a"} {
+		d.Duplicate(newTestMessage("t", 0, 0, p))
+	}
+	// "a" is suppressed once; after "c" it falls outside the two entry window so is accepted again
+	if s := d.Stats(); s.Checked != 5 || s.Suppressed != 1 || s.Entries != 2 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+
+	d = NewDeduplicator(DedupByPayloadHash, 20*time.Millisecond, 0)
+	if d.Duplicate(newTestMessage("t", 0, 0, "a")) || !d.Duplicate(newTestMessage("t", 0, 0, "a")) {
+		t.Fatalf("duplicate not detected within window")
+	}
+	time.Sleep(30 * time.Millisecond)
+	if d.Duplicate(newTestMessage("t", 0, 0, "a")) {
+		t.Fatalf("entry should have expired")
+	}
+}
+
+func Test_DedupFilePersistence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dedup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "keys")
+
+	p := NewFileDedupPersistence(path)
+	d := NewDeduplicator(DedupByPayloadHash, time.Hour, 100)
+	if err := d.SetPersistence(p); err != nil {
+		t.Fatalf("unexpected error setting persistence: %s", err)
+	}
+	d.Duplicate(newTestMessage("t", 1, 1, "a"))
+	d.Duplicate(newTestMessage("t", 1, 2, "b"))
+	p.Close()
+
+	// Simulate a restart
+	d = NewDeduplicator(DedupByPayloadHash, time.Hour, 100)
+	if err := d.SetPersistence(NewFileDedupPersistence(path)); err != nil {
+		t.Fatalf("unexpected error loading persistence: %s", err)
+	}
+	if !d.Duplicate(newTestMessage("t", 1, 3, "a")) || !d.Duplicate(newTestMessage("t", 1, 4, "b")) {
+		t.Fatalf("persisted keys not loaded")
+	}
+	if d.Duplicate(newTestMessage("t", 1, 5, "c")) {
+		t.Fatalf("new message reported as duplicate")
+	}
+}
+
+func Test_MatchAndDispatch_Dedup(t *testing.T) {
+	calls := make(chan Message, 10)
+	router := newRouter()
+	router.addRoute("a", func(c Client, m Message) { calls <- m })
+
+	d := NewDeduplicator(DedupByMessageID, time.Minute, 0)
+	msgs := make(chan *packets.PublishPacket)
+	store := NewMemoryStore()
+	store.Open()
+	acks := router.matchAndDispatch(msgs, true, &client{persist: store, options: ClientOptions{Deduplicator: d}})
+
+	for _, dup := range []bool{false, true} {
+		pub := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+		pub.Qos = 1
+		pub.Dup = dup
+		pub.MessageID = 12
+		pub.TopicName = "a"
+		pub.Payload = []byte("foo")
+		msgs <- pub
+		select {
+		case a := <-acks: // both the original and the duplicate must be acknowledged
+			if a.p.Details().MessageID != 12 {
+				t.Fatalf("unexpected ack %v", a.p)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("message was not acknowledged")
+		}
+	}
+	close(msgs)
+
+	if len(calls) != 1 {
+		t.Fatalf("handler called %d times, expected 1", len(calls))
+	}
+	if s := d.Stats(); s.Suppressed != 1 {
+		t.Fatalf("expected 1 suppressed message, got %d", s.Suppressed)
+	}
+}
@@ -0,0 +1,70 @@
+package mqtt
+
+import (
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+func Test_ConnectionEvents(t *testing.T) {
+	// The broker only accepts MQTT 3.1 so the client must downgrade
+	broker := newTestBrokerWithConnack(t, func(cm *packets.ConnectPacket) byte {
+		if cm.ProtocolVersion != 3 {
+			return packets.ErrRefusedBadProtocolVersion
+		}
+		return packets.Accepted
+	})
+	defer broker.Close()
+	bad := closedPortURL(t)
+
+	handler, events := ConnectionEventChannel(20)
+	ops := NewClientOptions().AddBroker(bad).AddBroker(broker.URL()).SetConnectionEventHandler(handler).
+		SetKeepAlive(0).SetWriteTimeout(time.Second).SetAutoReconnect(false)
+	c := NewClient(ops)
+	if token := c.Connect(); !token.WaitTimeout(5*time.Second) || token.Error() != nil {
+		t.Fatalf("connect failed: %v", token.Error())
+	}
+	c.Disconnect(10)
+
+	expected := []struct {
+		typ    ConnectionEventType
+		broker string
+	}{
+		{EventDialStarted, bad},
+		{EventDialFailed, bad},
+		{EventDialStarted, broker.URL()},
+		{EventConnackReceived, broker.URL()},
+		{EventProtocolDowngrade, broker.URL()},
+		{EventDialStarted, broker.URL()},
+		{EventConnackReceived, broker.URL()},
+	}
+	for i, exp := range expected {
+		var e ConnectionEvent
+		select {
+		case e = <-events:
+		default:
+			t.Fatalf("event %d (%s) not received", i, exp.typ)
+		}
+		if e.Type != exp.typ || e.Broker == nil || e.Broker.String() != exp.broker || e.Time.IsZero() {
+			t.Fatalf("event %d: got %s for %v, expected %s for %s", i, e.Type, e.Broker, exp.typ, exp.broker)
+		}
+		switch i {
+		case 1:
+			if e.Err == nil {
+				t.Fatalf("dial failed event has no error")
+			}
+		case 3:
+			if e.ReturnCode != packets.ErrRefusedBadProtocolVersion || e.ProtocolVersion != 4 {
+				t.Fatalf("unexpected CONNACK event %+v", e)
+			}
+		case 6:
+			if e.ReturnCode != packets.Accepted || e.ProtocolVersion != 3 {
+				t.Fatalf("unexpected CONNACK event %+v", e)
+			}
+		}
+	}
+	if EventKeepaliveTimeout.String() != "keepalive timeout" {
+		t.Fatalf("unexpected event name %q", EventKeepaliveTimeout)
+	}
+}
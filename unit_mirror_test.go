@@ -0,0 +1,106 @@
+package mqtt
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// connStateClient wraps loopbackClient allowing the connection state to be controlled
+type connStateClient struct {
+	*loopbackClient
+	open bool
+}
+
+func (c *connStateClient) IsConnectionOpen() bool {
+	return c.open
+}
+
+func waitForUpdate(t *testing.T, ch <-chan MirrorUpdate) MirrorUpdate {
+	select {
+	case u := <-ch:
+		return u
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for mirror update")
+	}
+	return MirrorUpdate{}
+}
+
+func Test_TopicMirror(t *testing.T) {
+	var connectCalled, lostCalled bool
+	o := NewClientOptions()
+	o.SetOnConnectHandler(func(Client) { connectCalled = true })
+	o.SetConnectionLostHandler(func(Client, error) { lostCalled = true })
+	m := NewTopicMirror(o, "config/#", 1)
+	if !m.Stale() {
+		t.Fatalf("mirror should be stale before connection")
+	}
+
+	c := &connStateClient{loopbackClient: newLoopbackClient(), open: true}
+	o.OnConnect(c)
+	if !connectCalled {
+		t.Fatalf("existing OnConnect handler not called")
+	}
+	if m.Stale() {
+		t.Fatalf("mirror should not be stale once subscribed")
+	}
+
+	all, stopAll := m.Watch("config/#", 10)
+	defer stopAll()
+	b, stopB := m.Watch("config/b", 10)
+
+	c.Publish("config/a", 1, true, []byte("1"))
+	if u := waitForUpdate(t, all); u.Topic != "config/a" || u.Deleted {
+		t.Fatalf("unexpected update %+v", u)
+	}
+	c.Publish("config/b", 1, true, []byte("2"))
+	waitForUpdate(t, all)
+	if u := waitForUpdate(t, b); u.Topic != "config/b" || string(u.Message.Payload()) != "2" {
+		t.Fatalf("unexpected update %+v", u)
+	}
+	stopB()
+	stopB() // must be safe to call more than once
+	if _, ok := <-b; ok {
+		t.Fatalf("watch channel should be closed")
+	}
+
+	if msg, ok := m.Get("config/a"); !ok || string(msg.Payload()) != "1" {
+		t.Fatalf("Get returned wrong value")
+	}
+
+	changes := make(chan MirrorUpdate, 10)
+	m.OnChange(func(u MirrorUpdate) { changes <- u })
+	c.Publish("config/a", 1, true, []byte{}) // retained message deleted
+	if u := waitForUpdate(t, all); u.Topic != "config/a" || !u.Deleted {
+		t.Fatalf("unexpected update %+v", u)
+	}
+	if _, ok := m.Get("config/a"); ok {
+		t.Fatalf("deleted topic still present")
+	}
+	if u := waitForUpdate(t, changes); !u.Deleted {
+		t.Fatalf("change callback not called correctly: %+v", u)
+	}
+	snap := m.Snapshot()
+	if len(snap) != 1 || string(snap["config/b"].Payload()) != "2" {
+		t.Fatalf("unexpected snapshot %v", snap)
+	}
+
+	c.open = false
+	o.OnConnectionLost(c, errors.New("test"))
+	if !lostCalled {
+		t.Fatalf("existing OnConnectionLost handler not called")
+	}
+	if !m.Stale() {
+		t.Fatalf("mirror should be stale when connection lost")
+	}
+	c.open = true
+	o.OnConnect(c)
+	if m.Stale() {
+		t.Fatalf("mirror should not be stale following reconnection")
+	}
+
+	m.Close()
+	if _, ok := <-all; ok {
+		t.Fatalf("watch channel should be closed")
+	}
+}
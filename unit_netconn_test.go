@@ -0,0 +1,241 @@
+package mqtt
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"github.com/gorilla/websocket"
+)
+
+// newWebsocketTestBroker starts a testBroker that accepts websocket connections (using TLS if secure is true)
+func newWebsocketTestBroker(t *testing.T, secure bool) (*testBroker, *httptest.Server) {
+	b := &testBroker{
+		connects: make(chan *packets.ConnectPacket, 10),
+		connack:  func(*packets.ConnectPacket) byte { return packets.Accepted },
+	}
+	upgrader := websocket.Upgrader{Subprotocols: []string{"mqtt"}}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		b.serve(&websocketConnector{Conn: ws})
+	})
+	var srv *httptest.Server
+	if secure {
+		srv = httptest.NewTLSServer(handler)
+	} else {
+		srv = httptest.NewServer(handler)
+	}
+	b.l = srv.Listener
+	b.scheme = "ws"
+	if secure {
+		b.scheme = "wss"
+	}
+	return b, srv
+}
+
+func Test_ConnectTLSConnectionState(t *testing.T) {
+	broker, brokerCert := newTLSTestBroker(t, &tls.Config{NextProtos: []string{"mqtt"}})
+	defer broker.Close()
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(brokerCert)
+
+	ops := NewClientOptions().AddBroker(broker.URL()).SetTLSConfig(&tls.Config{RootCAs: pool, NextProtos: []string{"mqtt"}}).
+		SetKeepAlive(0).SetWriteTimeout(time.Second).SetAutoReconnect(false)
+	c := NewClient(ops)
+	token := c.Connect()
+	if !token.WaitTimeout(5*time.Second) || token.Error() != nil {
+		t.Fatalf("connect failed: %v", token.Error())
+	}
+	c.Disconnect(10)
+	state := token.(*ConnectToken).TLSConnectionState()
+	if state == nil {
+		t.Fatalf("no TLS connection state")
+	}
+	if !state.HandshakeComplete || state.Version == 0 || state.CipherSuite == 0 || state.NegotiatedProtocol != "mqtt" {
+		t.Fatalf("unexpected TLS state %+v", state)
+	}
+	if len(state.PeerCertificates) != 1 || state.PeerCertificates[0].Subject.CommonName != "broker" {
+		t.Fatalf("unexpected peer certificates")
+	}
+
+	// Plain TCP connections have no TLS state
+	plain := newTestBroker(t)
+	defer plain.Close()
+	c = NewClient(NewClientOptions().AddBroker(plain.URL()).SetKeepAlive(0).SetWriteTimeout(time.Second))
+	token = c.Connect()
+	if !token.WaitTimeout(5*time.Second) || token.Error() != nil {
+		t.Fatalf("connect failed: %v", token.Error())
+	}
+	c.Disconnect(10)
+	if token.(*ConnectToken).TLSConnectionState() != nil {
+		t.Fatalf("unexpected TLS state for tcp connection")
+	}
+}
+
+func Test_ConnectWebsocketTLSConnectionState(t *testing.T) {
+	broker, srv := newWebsocketTestBroker(t, true)
+	defer srv.Close()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(srv.Certificate())
+	ops := NewClientOptions().AddBroker(broker.URL()).SetTLSConfig(&tls.Config{RootCAs: pool}).
+		SetKeepAlive(0).SetWriteTimeout(time.Second).SetAutoReconnect(false)
+	c := NewClient(ops)
+	token := c.Connect()
+	if !token.WaitTimeout(5*time.Second) || token.Error() != nil {
+		t.Fatalf("connect failed: %v", token.Error())
+	}
+	c.Disconnect(10)
+	if !strings.HasPrefix(broker.URL(), "wss://") {
+		t.Fatalf("unexpected url %s", broker.URL())
+	}
+	state := token.(*ConnectToken).TLSConnectionState()
+	if state == nil || !state.HandshakeComplete || len(state.PeerCertificates) == 0 {
+		t.Fatalf("unexpected TLS state %+v", state)
+	}
+}
+
+func Test_TLSOverrides(t *testing.T) {
+	u := testBrokerURLs("wss://example.com:443/mqtt?alpn=x-amzn-mqtt-ca,mqtt&sni=broker.example.com&token=abc")[0]
+	insecure := true
+	o := tlsOverrideFor(u, map[string]TLSOverride{"example.com:443": {ServerName: "other", InsecureSkipVerify: &insecure}})
+	if len(o.NextProtos) != 2 || o.NextProtos[0] != "x-amzn-mqtt-ca" || o.ServerName != "broker.example.com" || !*o.InsecureSkipVerify {
+		t.Fatalf("unexpected override %+v", o)
+	}
+	if s := withoutTLSOverrides(u).String(); s != "wss://example.com:443/mqtt?token=abc" {
+		t.Fatalf("override parameters not removed: %s", s)
+	}
+	base := &tls.Config{ServerName: "base"}
+	if c := o.apply(base); c == base || c.ServerName != "broker.example.com" || base.ServerName != "base" {
+		t.Fatalf("override not applied to a copy")
+	}
+	if c := (TLSOverride{}).apply(base); c != base {
+		t.Fatalf("empty override should not copy the config")
+	}
+
+	// insecure=false in the URL re-enables verification
+	u = testBrokerURLs("ssl://example.com:8883?insecure=false")[0]
+	o = tlsOverrideFor(u, map[string]TLSOverride{"example.com:8883": {InsecureSkipVerify: &insecure}})
+	if c := o.apply(&tls.Config{InsecureSkipVerify: true}); c.InsecureSkipVerify {
+		t.Fatalf("insecure=false did not enable verification")
+	}
+	if c := (TLSOverride{}).apply(&tls.Config{InsecureSkipVerify: true}); !c.InsecureSkipVerify {
+		t.Fatalf("InsecureSkipVerify changed without an override")
+	}
+}
+
+func Test_ConnectTLSOverride(t *testing.T) {
+	// The broker certificate is only valid for 127.0.0.1 and localhost so the SNI override must be used
+	// for verification to succeed
+	sni := make(chan string, 1)
+	protos := make(chan []string, 1)
+	broker, brokerCert := newTLSTestBroker(t, &tls.Config{
+		NextProtos: []string{"x-amzn-mqtt-ca"},
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			sni <- hello.ServerName
+			protos <- hello.SupportedProtos
+			return nil, nil
+		},
+	})
+	defer broker.Close()
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(brokerCert)
+
+	ops := NewClientOptions().AddBroker(broker.URL() + "?alpn=x-amzn-mqtt-ca&sni=localhost").SetTLSConfig(&tls.Config{RootCAs: pool}).
+		SetKeepAlive(0).SetWriteTimeout(time.Second).SetAutoReconnect(false)
+	c := NewClient(ops)
+	token := c.Connect()
+	if !token.WaitTimeout(5*time.Second) || token.Error() != nil {
+		t.Fatalf("connect failed: %v", token.Error())
+	}
+	c.Disconnect(10)
+	if s := <-sni; s != "localhost" {
+		t.Fatalf("unexpected SNI %q", s)
+	}
+	if p := <-protos; len(p) != 1 || p[0] != "x-amzn-mqtt-ca" {
+		t.Fatalf("unexpected ALPN protocols %v", p)
+	}
+	if state := token.(*ConnectToken).TLSConnectionState(); state.NegotiatedProtocol != "x-amzn-mqtt-ca" || state.ServerName != "localhost" {
+		t.Fatalf("unexpected TLS state %+v", state)
+	}
+}
+
+func Test_ConnectTLSInsecureSNI(t *testing.T) {
+	// SNI must be sent even when verification is disabled (brokers may route on it)
+	sni := make(chan string, 1)
+	broker, _ := newTLSTestBroker(t, &tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			sni <- hello.ServerName
+			return nil, nil
+		},
+	})
+	defer broker.Close()
+
+	ops := NewClientOptions().AddBroker("ssl://localhost:" + portOf(t, broker.l.Addr())).SetTLSConfig(&tls.Config{InsecureSkipVerify: true}).
+		SetKeepAlive(0).SetWriteTimeout(time.Second).SetAutoReconnect(false)
+	c := NewClient(ops)
+	if token := c.Connect(); !token.WaitTimeout(5*time.Second) || token.Error() != nil {
+		t.Fatalf("connect failed: %v", token.Error())
+	}
+	c.Disconnect(10)
+	if s := <-sni; s != "localhost" {
+		t.Fatalf("unexpected SNI %q", s)
+	}
+}
+
+var (
+	envProxyOnce sync.Once
+	envProxy     *testProxy
+)
+
+// envProxyURL returns the URL of a SOCKS5 proxy for use in all_proxy; golang.org/x/net/proxy only reads the
+// environment once per process so the same proxy must be used by every test (and every run with -count)
+func envProxyURL(t *testing.T) string {
+	envProxyOnce.Do(func() { envProxy = newTestProxy(t, socks5Proxy) })
+	return "socks5://user:pass@" + envProxy.l.Addr().String()
+}
+
+func Test_ConnectTLSViaEnvironmentProxy(t *testing.T) {
+	broker, brokerCert := newTLSTestBroker(t, nil)
+	defer broker.Close()
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(brokerCert)
+
+	for k, v := range map[string]string{"all_proxy": envProxyURL(t), "no_proxy": ""} {
+		defer os.Setenv(k, os.Getenv(k))
+		os.Setenv(k, v)
+	}
+
+	// With no proxy set in the options the connection is made via all_proxy and the TLS handshake completed over it
+	server := "localhost:" + portOf(t, broker.l.Addr())
+	ops := NewClientOptions().AddBroker("ssl://" + server).SetTLSConfig(&tls.Config{RootCAs: pool}).
+		SetKeepAlive(0).SetWriteTimeout(time.Second).SetAutoReconnect(false)
+	c := NewClient(ops)
+	token := c.Connect()
+	if !token.WaitTimeout(5*time.Second) || token.Error() != nil {
+		t.Fatalf("connect failed: %v", token.Error())
+	}
+	c.Disconnect(10)
+	select {
+	case target := <-envProxy.targets:
+		if target != server {
+			t.Fatalf("proxy connected to %s, expected %s", target, server)
+		}
+	default:
+		t.Fatal("connection not made via all_proxy")
+	}
+	state := token.(*ConnectToken).TLSConnectionState()
+	if state == nil || !state.HandshakeComplete || state.ServerName != "localhost" || len(state.PeerCertificates) != 1 {
+		t.Fatalf("unexpected TLS state %+v", state)
+	}
+}
@@ -0,0 +1,134 @@
+package mqtt
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"github.com/gorilla/websocket"
+)
+
+func Test_ProxyProtocolHeaderEncode(t *testing.T) {
+	src := &net.TCPAddr{IP: net.ParseIP("10.1.2.3"), Port: 40000}
+	dst := &net.TCPAddr{IP: net.ParseIP("192.168.0.1"), Port: 1883}
+
+	b, err := (&ProxyProtocolHeader{Version: 1, Source: src}).encode(nil, dst)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if s := string(b); s != "PROXY TCP4 10.1.2.3 192.168.0.1 40000 1883\r\n" {
+		t.Fatalf("unexpected v1 header %q", s)
+	}
+
+	b, err = (&ProxyProtocolHeader{Version: 2, Source: src, Destination: dst}).encode(nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	want := "0d0a0d0a000d0a515549540a" + "21" + "11" + "000c" + "0a010203" + "c0a80001" + "9c40" + "075b"
+	if h := hex.EncodeToString(b); h != want {
+		t.Fatalf("unexpected v2 header %s, expected %s", h, want)
+	}
+
+	// Mixed address families are sent as IPv6
+	b, err = (&ProxyProtocolHeader{Version: 1, Source: &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 1}}).encode(nil, dst)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if s := string(b); s != "PROXY TCP6 2001:db8::1 ::ffff:192.168.0.1 1 1883\r\n" {
+		t.Fatalf("unexpected v1 header %q", s)
+	}
+
+	if _, err = (&ProxyProtocolHeader{Version: 3}).encode(src, dst); err == nil {
+		t.Fatalf("expected an error for an unsupported version")
+	}
+}
+
+func Test_ConnectWithProxyProtocol(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to listen: %s", err)
+	}
+	broker := &testBroker{l: l, connects: make(chan *packets.ConnectPacket, 10),
+		connack: func(*packets.ConnectPacket) byte { return packets.Accepted }}
+	defer broker.Close()
+	headers := make(chan string, 1)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			br := bufio.NewReader(conn)
+			line, err := br.ReadString('\n')
+			if err != nil {
+				conn.Close()
+				continue
+			}
+			headers <- line
+			go broker.serve(&bufferedConn{Conn: conn, r: br})
+		}
+	}()
+
+	src := &net.TCPAddr{IP: net.ParseIP("203.0.113.7"), Port: 5555}
+	ops := NewClientOptions().AddBroker(broker.URL()).SetKeepAlive(0).SetWriteTimeout(time.Second).
+		SetAutoReconnect(false).SetProxyProtocol(&ProxyProtocolHeader{Version: 1, Source: src})
+	c := NewClient(ops)
+	if token := c.Connect(); !token.WaitTimeout(5*time.Second) || token.Error() != nil {
+		t.Fatalf("connect failed: %v", token.Error())
+	}
+	defer c.Disconnect(10)
+
+	select {
+	case h := <-headers:
+		want := "PROXY TCP4 203.0.113.7 127.0.0.1 5555 " + portOf(t, l.Addr()) + "\r\n"
+		if h != want {
+			t.Fatalf("received header %q, expected %q", h, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no header received")
+	}
+}
+
+func Test_WebsocketProxyProtocolEnvironmentProxy(t *testing.T) {
+	// Connections that do not go via a proxy are unaffected; those that would are rejected
+	req, _ := http.NewRequest("GET", "http://broker.example.com/mqtt", nil)
+	direct := proxyProtocolProxy(func(*http.Request) (*url.URL, error) { return nil, nil })
+	if u, err := direct(req); u != nil || err != nil {
+		t.Fatalf("unexpected proxy %v (error %v)", u, err)
+	}
+	proxied := proxyProtocolProxy(func(*http.Request) (*url.URL, error) { return url.Parse("http://proxy.example.com:3128") })
+	if u, err := proxied(req); u != nil || err == nil || !strings.Contains(err.Error(), "proxy.example.com:3128") {
+		t.Fatalf("expected error for proxied connection, got %v (error %v)", u, err)
+	}
+
+	// The environment is only consulted when no proxy was set in the options
+	refused := errors.New("refused")
+	forward := contextDialerFunc(func(context.Context, string, string) (net.Conn, error) { return nil, refused })
+	for _, environment := range []bool{true, false} {
+		var proxy func(*http.Request) (*url.URL, error)
+		options := &WebsocketOptions{DialerHook: func(d *websocket.Dialer) { proxy = d.Proxy }}
+		dialer := &proxyProtocolDialer{header: &ProxyProtocolHeader{Version: 1}, forward: forward, environment: environment}
+		if _, err := newWebsocket(context.Background(), "ws://127.0.0.1:1/mqtt", nil, time.Second, nil, options, dialer); err == nil {
+			t.Fatalf("expected dial to fail")
+		}
+		if (proxy != nil) != environment {
+			t.Fatalf("environment %v: unexpected websocket proxy setting", environment)
+		}
+	}
+}
+
+func portOf(t *testing.T, a net.Addr) string {
+	_, port, err := net.SplitHostPort(a.String())
+	if err != nil {
+		t.Fatalf("invalid address %s", a)
+	}
+	return port
+}
@@ -0,0 +1,193 @@
+package mqtt
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// testProxy is a minimal HTTP CONNECT or SOCKS5 proxy
+type testProxy struct {
+	l       net.Listener
+	targets chan string // addresses that connections were requested to
+}
+
+func newTestProxy(t *testing.T, handle func(p *testProxy, conn net.Conn)) *testProxy {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to listen: %s", err)
+	}
+	p := &testProxy{l: l, targets: make(chan string, 10)}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go handle(p, conn)
+		}
+	}()
+	return p
+}
+
+func (p *testProxy) Close() {
+	p.l.Close()
+}
+
+// tunnel connects conn to target
+func (p *testProxy) tunnel(conn net.Conn, target string) {
+	p.targets <- target
+	tc, err := net.Dial("tcp", target)
+	if err != nil {
+		return
+	}
+	go func() {
+		io.Copy(tc, conn)
+		tc.Close()
+	}()
+	io.Copy(conn, tc)
+}
+
+// httpConnectProxy handles HTTP CONNECT requests requiring basic authentication as user/pass
+func httpConnectProxy(p *testProxy, conn net.Conn) {
+	defer conn.Close()
+	req, err := http.ReadRequest(bufio.NewReader(conn))
+	if err != nil {
+		return
+	}
+	if req.Method != http.MethodConnect {
+		io.WriteString(conn, "HTTP/1.1 405 Method Not Allowed\r\n\r\n")
+		return
+	}
+	if req.Header.Get("Proxy-Authorization") != "Basic dXNlcjpwYXNz" {
+		io.WriteString(conn, "HTTP/1.1 407 Proxy Authentication Required\r\n\r\n")
+		return
+	}
+	io.WriteString(conn, "HTTP/1.1 200 OK\r\n\r\n")
+	p.tunnel(conn, req.Host)
+}
+
+// socks5Proxy handles SOCKS5 CONNECT requests requiring username/password authentication as user/pass
+func socks5Proxy(p *testProxy, conn net.Conn) {
+	defer conn.Close()
+	buf := make([]byte, 512)
+	// greeting: version, number of methods, methods
+	if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+		return
+	}
+	if _, err := io.ReadFull(conn, buf[:buf[1]]); err != nil {
+		return
+	}
+	conn.Write([]byte{5, 2}) // username/password
+	// authentication: version, username, password
+	if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+		return
+	}
+	user := make([]byte, buf[1])
+	io.ReadFull(conn, user)
+	io.ReadFull(conn, buf[:1])
+	pass := make([]byte, buf[0])
+	io.ReadFull(conn, pass)
+	if string(user) != "user" || string(pass) != "pass" {
+		conn.Write([]byte{1, 1})
+		return
+	}
+	conn.Write([]byte{1, 0})
+	// request: version, command, reserved, address type, address, port
+	if _, err := io.ReadFull(conn, buf[:4]); err != nil {
+		return
+	}
+	var host string
+	switch buf[3] {
+	case 1:
+		io.ReadFull(conn, buf[:4])
+		host = net.IP(buf[:4]).String()
+	case 3:
+		io.ReadFull(conn, buf[:1])
+		name := make([]byte, buf[0])
+		io.ReadFull(conn, name)
+		host = string(name)
+	default:
+		return
+	}
+	io.ReadFull(conn, buf[:2])
+	port := binary.BigEndian.Uint16(buf[:2])
+	conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+	p.tunnel(conn, net.JoinHostPort(host, strconv.Itoa(int(port))))
+}
+
+func Test_ConnectViaProxy(t *testing.T) {
+	broker := newTestBroker(t)
+	defer broker.Close()
+	wsBroker, wsSrv := newWebsocketTestBroker(t, false)
+	defer wsSrv.Close()
+
+	for _, tc := range []struct {
+		name   string
+		scheme string
+		handle func(p *testProxy, conn net.Conn)
+	}{
+		{"http", "http", httpConnectProxy},
+		{"socks5", "socks5", socks5Proxy},
+	} {
+		p := newTestProxy(t, tc.handle)
+		proxyURL, _ := url.Parse(tc.scheme + "://user:pass@" + p.l.Addr().String())
+		for _, b := range []*testBroker{broker, wsBroker} {
+			ops := NewClientOptions().AddBroker(b.URL()).SetProxy(proxyURL).
+				SetKeepAlive(0).SetWriteTimeout(time.Second).SetAutoReconnect(false)
+			c := NewClient(ops)
+			if token := c.Connect(); !token.WaitTimeout(5*time.Second) || token.Error() != nil {
+				t.Fatalf("%s: connect to %s failed: %v", tc.name, b.URL(), token.Error())
+			}
+			c.Disconnect(10)
+			if target := <-p.targets; target != b.l.Addr().String() {
+				t.Fatalf("%s: proxy connected to %s, expected %s", tc.name, target, b.l.Addr())
+			}
+		}
+
+		// Incorrect credentials should be rejected
+		proxyURL.User = url.UserPassword("user", "wrong")
+		c := NewClient(NewClientOptions().AddBroker(broker.URL()).SetProxy(proxyURL).SetWriteTimeout(time.Second))
+		if token := c.Connect(); !token.WaitTimeout(5*time.Second) || token.Error() == nil {
+			t.Fatalf("%s: expected connect to fail with incorrect credentials", tc.name)
+		}
+		p.Close()
+	}
+}
+
+func Test_ProxyConnectTimeout(t *testing.T) {
+	// This proxy accepts the connection but never responds
+	p := newTestProxy(t, func(p *testProxy, conn net.Conn) {
+		io.Copy(ioutil.Discard, conn)
+	})
+	defer p.Close()
+	proxyURL, _ := url.Parse("http://" + p.l.Addr().String())
+
+	ops := NewClientOptions().AddBroker("tcp://127.0.0.1:1883").SetProxy(proxyURL).SetConnectTimeout(100 * time.Millisecond)
+	c := NewClient(ops)
+	start := time.Now()
+	token := c.Connect()
+	if !token.WaitTimeout(5*time.Second) || token.Error() == nil {
+		t.Fatalf("expected connect to time out")
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Fatalf("connect took %s", d)
+	}
+
+	// Disconnect should cancel a connection attempt in progress
+	ops.SetConnectTimeout(0).SetConnectRetry(true)
+	c = NewClient(ops)
+	token = c.Connect()
+	time.Sleep(50 * time.Millisecond)
+	c.Disconnect(0)
+	if !token.WaitTimeout(5*time.Second) || token.Error() == nil {
+		t.Fatalf("expected connect to be cancelled")
+	}
+}
@@ -0,0 +1,116 @@
+package mqtt
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+func Test_RawSession(t *testing.T) {
+	c, peer := net.Pipe()
+	s := NewRawSession(c, RawSessionOptions{AutoPingResponse: true, WriteTimeout: time.Second})
+	defer s.Close()
+	defer peer.Close()
+
+	// Packets are sent unchanged
+	go func() {
+		cm := packets.NewControlPacket(packets.Connect).(*packets.ConnectPacket)
+		cm.ProtocolName, cm.ProtocolVersion, cm.ClientIdentifier = "MQTT", 4, "raw"
+		s.Send(cm)
+	}()
+	cp, err := packets.ReadPacket(peer)
+	if err != nil {
+		t.Fatalf("error reading CONNECT: %v", err)
+	}
+	if cm, ok := cp.(*packets.ConnectPacket); !ok || cm.ClientIdentifier != "raw" {
+		t.Fatalf("unexpected packet %v", cp)
+	}
+
+	// Crafted flags
+	go s.SendWithFlags(packets.NewControlPacket(packets.Pingreq), 0x0F)
+	b := make([]byte, 2)
+	if _, err = io.ReadFull(peer, b); err != nil {
+		t.Fatalf("error reading PINGREQ: %v", err)
+	}
+	if !bytes.Equal(b, []byte{0xCF, 0x00}) {
+		t.Fatalf("expected flags to be replaced, got % x", b)
+	}
+
+	// A PINGREQ from the peer is answered automatically and not returned by Receive
+	go func() {
+		peer.Write([]byte{0xC0, 0x00})
+		peer.Write([]byte{0x20, 0x02, 0x00, 0x00}) // CONNACK
+	}()
+	if _, err = io.ReadFull(peer, b); err != nil {
+		t.Fatalf("error reading PINGRESP: %v", err)
+	}
+	if !bytes.Equal(b, []byte{0xD0, 0x00}) {
+		t.Fatalf("expected PINGRESP, got % x", b)
+	}
+	cp, err = s.Expect(packets.Connack, time.Second)
+	if err != nil {
+		t.Fatalf("expected CONNACK: %v", err)
+	}
+	if ca := cp.(*packets.ConnackPacket); ca.ReturnCode != packets.Accepted {
+		t.Fatalf("unexpected return code %d", ca.ReturnCode)
+	}
+
+	// Wrong packet type
+	go peer.Write([]byte{0x40, 0x02, 0x00, 0x01}) // PUBACK
+	cp, err = s.Expect(packets.Suback, time.Second)
+	if !errors.Is(err, ErrUnexpectedPacket) {
+		t.Fatalf("expected ErrUnexpectedPacket, got %v", err)
+	}
+	if pa, ok := cp.(*packets.PubackPacket); !ok || pa.MessageID != 1 {
+		t.Fatalf("expected PUBACK to be returned, got %v", cp)
+	}
+
+	// Timeout (the packet arriving afterwards must not be lost)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err = s.Receive(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("expected DeadlineExceeded, got %v", err)
+	}
+	go peer.Write([]byte{0xD0, 0x00})
+	if _, err = s.Expect(packets.Pingresp, time.Second); err != nil {
+		t.Fatalf("expected PINGRESP: %v", err)
+	}
+
+	// Malformed packets are reported along with the bytes read
+	go peer.Write([]byte{0x40, 0x01, 0x00})
+	_, frame, err := s.ReceiveFrame(context.Background())
+	var de *packets.DecodeError
+	if !errors.As(err, &de) || de.PacketType != packets.Puback {
+		t.Fatalf("expected DecodeError, got %v", err)
+	}
+	if !bytes.Equal(frame, []byte{0x40, 0x01, 0x00}) {
+		t.Fatalf("unexpected frame % x", frame)
+	}
+	if _, err2 := s.Receive(context.Background()); err2 != err {
+		t.Fatalf("expected error to be repeated, got %v", err2)
+	}
+}
+
+func Test_RawSessionClose(t *testing.T) {
+	c, peer := net.Pipe()
+	defer peer.Close()
+	s := NewRawSession(c, RawSessionOptions{})
+	if err := s.Close(); err != nil {
+		t.Fatalf("unexpected error closing: %v", err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("unexpected error closing twice: %v", err)
+	}
+	if _, err := s.Receive(context.Background()); err != ErrRawSessionClosed {
+		t.Fatalf("expected ErrRawSessionClosed after Close, got %v", err)
+	}
+	if err := s.SendBytes([]byte{0xE0, 0x00}); err == nil {
+		t.Fatal("expected error sending after Close")
+	}
+}
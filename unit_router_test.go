@@ -350,3 +350,57 @@ func Test_SharedSubscription_MatchAndDispatch(t *testing.T) {
 	}
 
 }
+
+func Test_SharedSubscription_Routes(t *testing.T) {
+	tests := []struct {
+		filter, route string
+	}{
+		{"jobs/#", "jobs/#"},
+		{"$share/workers/jobs/#", "jobs/#"},
+		{"$share/workers//a", "/a"},
+		{"$queue/jobs/+", "jobs/+"},
+		{"$shared/a", "$shared/a"},
+		{"$SYS/#", "$SYS/#"},
+	}
+	for _, test := range tests {
+		if r := routeTopic(test.filter); r != test.route {
+			t.Errorf("routeTopic(%q) = %q, expected %q", test.filter, r, test.route)
+		}
+	}
+
+	router := newRouter()
+	cb := func(client Client, msg Message) {}
+	router.addRoute("$share/workers/jobs/#", cb)
+	router.addRoute("$queue/tasks", cb)
+	if topic := router.routes.Front().Value.(*route).topic; topic != "jobs/#" {
+		t.Fatalf("expected shared subscription prefix to be removed from route, got %q", topic)
+	}
+	if !router.routes.Front().Value.(*route).match("jobs/1") {
+		t.Fatalf("expected shared subscription route to match topic")
+	}
+	router.deleteRoute("$share/workers/jobs/#")
+	router.deleteRoute("$queue/tasks")
+	if router.routes.Len() != 0 {
+		t.Fatalf("expected routes to be deleted, %d remain", router.routes.Len())
+	}
+}
+
+func Test_SubscribeShared(t *testing.T) {
+	clients := []*loopbackClient{newLoopbackClient(), newLoopbackClient(), newLoopbackClient()}
+	pool := make([]Client, len(clients))
+	for i, c := range clients {
+		pool[i] = c
+	}
+	tokens := SubscribeShared(pool, "workers", "jobs/#", 1, func(Client, Message) {})
+	if len(tokens) != len(clients) {
+		t.Fatalf("expected %d tokens, got %d", len(clients), len(tokens))
+	}
+	for i, c := range clients {
+		if err := tokens[i].Error(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, ok := c.routes["$share/workers/jobs/#"]; !ok {
+			t.Fatalf("client %d not subscribed to shared subscription", i)
+		}
+	}
+}
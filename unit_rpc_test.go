@@ -0,0 +1,120 @@
+package mqtt
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+// loopbackClient is a Client that delivers published messages to its own subscriptions (without a broker)
+type loopbackClient struct {
+	Client // not set; calling unimplemented methods will panic
+	mu     sync.Mutex
+	routes map[string]MessageHandler
+}
+
+func newLoopbackClient() *loopbackClient {
+	return &loopbackClient{routes: make(map[string]MessageHandler)}
+}
+
+func (l *loopbackClient) Subscribe(topic string, qos byte, callback MessageHandler) Token {
+	l.mu.Lock()
+	l.routes[topic] = callback
+	l.mu.Unlock()
+	t := newToken(packets.Subscribe)
+	t.flowComplete()
+	return t
+}
+
+func (l *loopbackClient) Unsubscribe(topics ...string) Token {
+	l.mu.Lock()
+	for _, topic := range topics {
+		delete(l.routes, topic)
+	}
+	l.mu.Unlock()
+	t := newToken(packets.Unsubscribe)
+	t.flowComplete()
+	return t
+}
+
+func (l *loopbackClient) Publish(topic string, qos byte, retained bool, payload interface{}) Token {
+	pub := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+	pub.TopicName = topic
+	pub.Qos = qos
+	pub.Payload = payload.([]byte)
+	l.mu.Lock()
+	var handlers []MessageHandler
+	for route, h := range l.routes {
+		if routeIncludesTopic(route, topic) {
+			handlers = append(handlers, h)
+		}
+	}
+	l.mu.Unlock()
+	for _, h := range handlers {
+		go h(l, messageFromPublish(pub, func() {}))
+	}
+	t := newToken(packets.Publish)
+	t.flowComplete()
+	return t
+}
+
+func Test_RequestResponse(t *testing.T) {
+	c := newLoopbackClient()
+	resp := NewResponder(c, 1)
+	resp.Handle("cmd/+", func(req []byte) ([]byte, error) {
+		if string(req) == "fail" {
+			return nil, errors.New("failed as requested")
+		}
+		return []byte(strings.ToUpper(string(req))), nil
+	})
+
+	r, err := NewRequester(c, "resp/dev1", 1)
+	if err != nil {
+		t.Fatalf("unexpected error creating requester: %s", err)
+	}
+
+	var wg sync.WaitGroup
+	for _, req := range []string{"a", "b", "c", "d"} {
+		wg.Add(1)
+		go func(req string) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			got, err := r.Request(ctx, "cmd/dev1", []byte(req))
+			if err != nil {
+				t.Errorf("unexpected error from request: %s", err)
+				return
+			}
+			if string(got) != strings.ToUpper(req) {
+				t.Errorf("response %q does not match request %q", got, req)
+			}
+		}(req)
+	}
+	wg.Wait()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := r.Request(ctx, "cmd/dev1", []byte("fail")); err != ResponseError("failed as requested") {
+		t.Fatalf("expected ResponseError, got %v", err)
+	}
+
+	// Nothing is listening on this topic so the request should time out
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := r.Request(ctx, "nobody/home", []byte("x")); err != context.DeadlineExceeded {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+
+	r.Close()
+	if _, err := r.Request(context.Background(), "cmd/dev1", []byte("x")); err != ErrRequesterClosed {
+		t.Fatalf("expected ErrRequesterClosed, got %v", err)
+	}
+	if len(r.pending) != 0 {
+		t.Fatalf("pending requests not cleaned up: %d", len(r.pending))
+	}
+}
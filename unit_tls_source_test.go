@@ -0,0 +1,208 @@
+package mqtt
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+// testCertificate generates a self signed certificate (valid for 127.0.0.1 and localhost) returning PEM
+// encoded certificate and key
+func testCertificate(t *testing.T, cn string, notAfter time.Time) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              notAfter,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		DNSNames:              []string{"localhost"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+}
+
+// newTLSTestBroker starts a testBroker that accepts TLS connections using a newly generated certificate
+// (which is returned in PEM format so that it can be trusted by the client)
+func newTLSTestBroker(t *testing.T, config *tls.Config) (*testBroker, []byte) {
+	certPEM, keyPEM := testCertificate(t, "broker", time.Now().Add(time.Hour))
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config == nil {
+		config = &tls.Config{}
+	}
+	config.Certificates = []tls.Certificate{cert}
+	l, err := tls.Listen("tcp", "127.0.0.1:0", config)
+	if err != nil {
+		t.Fatalf("unable to listen: %s", err)
+	}
+	b := startTestBroker(l, func(*packets.ConnectPacket) byte { return packets.Accepted })
+	b.scheme = "ssl"
+	return b, certPEM
+}
+
+func writeTestFile(t *testing.T, path string, data []byte, modTime time.Time) {
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_TLSFileSource(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tlssource")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certFile, keyFile, caFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem"), filepath.Join(dir, "ca.pem")
+
+	if _, err := NewTLSFileSource(certFile, keyFile, "", nil).Config(); err == nil {
+		t.Fatalf("expected an error when files do not exist")
+	}
+
+	now := time.Now()
+	certPEM, keyPEM := testCertificate(t, "first", now.Add(time.Hour))
+	writeTestFile(t, certFile, certPEM, now)
+	writeTestFile(t, keyFile, keyPEM, now)
+	writeTestFile(t, caFile, certPEM, now)
+	s := NewTLSFileSource(certFile, keyFile, caFile, &tls.Config{ServerName: "broker"})
+	c1, err := s.Config()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if c1.Certificates[0].Leaf.Subject.CommonName != "first" || c1.RootCAs == nil || c1.ServerName != "broker" {
+		t.Fatalf("unexpected configuration")
+	}
+	if c, _ := s.Config(); c != c1 {
+		t.Fatalf("configuration reloaded when files unchanged")
+	}
+
+	// Rotate the certificate
+	certPEM, keyPEM = testCertificate(t, "second", now.Add(time.Hour))
+	writeTestFile(t, certFile, certPEM, now.Add(time.Second))
+	writeTestFile(t, keyFile, keyPEM, now.Add(time.Second))
+	c2, err := s.Config()
+	if err != nil || c2.Certificates[0].Leaf.Subject.CommonName != "second" {
+		t.Fatalf("certificate not reloaded (%v)", err)
+	}
+
+	// A certificate without a matching key should be ignored
+	certPEM, _ = testCertificate(t, "third", now.Add(time.Hour))
+	writeTestFile(t, certFile, certPEM, now.Add(2*time.Second))
+	if c, err := s.Config(); err != nil || c != c2 {
+		t.Fatalf("previous configuration not retained (%v)", err)
+	}
+}
+
+func Test_TLSConfigProviderExpiry(t *testing.T) {
+	broker, brokerCert := newTLSTestBroker(t, nil)
+	defer broker.Close()
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(brokerCert)
+
+	var clientPEM, clientKey []byte
+	provider := func() (*tls.Config, error) {
+		cert, err := tls.X509KeyPair(clientPEM, clientKey)
+		if err != nil {
+			return nil, err
+		}
+		return &tls.Config{RootCAs: pool, Certificates: []tls.Certificate{cert}}, nil
+	}
+
+	handler, events := ConnectionEventChannel(20)
+	ops := NewClientOptions().AddBroker(broker.URL()).SetTLSConfigProvider(provider).SetTLSExpiryWarning(24 * time.Hour).
+		SetConnectionEventHandler(handler).SetKeepAlive(0).SetWriteTimeout(time.Second).SetAutoReconnect(false)
+	c := NewClient(ops)
+
+	// Certificate expires in an hour so a warning should be generated
+	clientPEM, clientKey = testCertificate(t, "client", time.Now().Add(time.Hour))
+	if token := c.Connect(); !token.WaitTimeout(5*time.Second) || token.Error() != nil {
+		t.Fatalf("connect failed: %v", token.Error())
+	}
+	c.Disconnect(10)
+	var expiring, handshake bool
+	for len(events) > 0 {
+		e := <-events
+		switch e.Type {
+		case EventCertificateExpiring:
+			expiring = !e.CertificateExpiry.IsZero()
+		case EventTLSHandshakeDone:
+			handshake = e.TLS != nil && len(e.TLS.PeerCertificates) > 0 && e.TLS.PeerCertificates[0].Subject.CommonName == "broker"
+		}
+	}
+	if !expiring || !handshake {
+		t.Fatalf("expected certificate expiring (%v) and TLS handshake (%v) events", expiring, handshake)
+	}
+
+	// An expired certificate should not be used
+	clientPEM, clientKey = testCertificate(t, "client", time.Now().Add(-time.Minute))
+	token := c.Connect()
+	if !token.WaitTimeout(5*time.Second) || token.Error() == nil || !errors.Is(token.Error(), ErrCertificateExpired) {
+		t.Fatalf("expected expired certificate error, got %v", token.Error())
+	}
+}
+
+func Test_StaticTLSConfigExpiredCertificate(t *testing.T) {
+	broker, brokerCert := newTLSTestBroker(t, nil)
+	defer broker.Close()
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(brokerCert)
+
+	// An expired certificate in a static configuration is still presented (only a warning is logged)
+	clientPEM, clientKey := testCertificate(t, "client", time.Now().Add(-time.Minute))
+	cert, err := tls.X509KeyPair(clientPEM, clientKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler, events := ConnectionEventChannel(20)
+	ops := NewClientOptions().AddBroker(broker.URL()).SetTLSConfig(&tls.Config{RootCAs: pool, Certificates: []tls.Certificate{cert}}).
+		SetConnectionEventHandler(handler).SetKeepAlive(0).SetWriteTimeout(time.Second).SetAutoReconnect(false)
+	c := NewClient(ops)
+	if token := c.Connect(); !token.WaitTimeout(5*time.Second) || token.Error() != nil {
+		t.Fatalf("connect failed: %v", token.Error())
+	}
+	c.Disconnect(10)
+	expiring := false
+	for len(events) > 0 {
+		if e := <-events; e.Type == EventCertificateExpiring {
+			expiring = true
+		}
+	}
+	if !expiring {
+		t.Fatal("expected certificate expiring event")
+	}
+}
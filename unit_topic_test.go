@@ -15,6 +15,7 @@
 package mqtt
 
 import (
+	"strings"
 	"testing"
 )
 
@@ -45,3 +46,92 @@ func Test_ValidateTopicAndQos_H(t *testing.T) {
 		t.Fatalf("invalid error for bad multilevel topic filter")
 	}
 }
+
+func Test_ValidateTopicName(t *testing.T) {
+	tests := []struct {
+		topic string
+		err   error
+	}{
+		{"a", nil},
+		{"/", nil},
+		{"a//b", nil},
+		{"a b/ü", nil},
+		{"$SYS/x", nil},
+		{"", ErrInvalidTopicEmptyString},
+		{"a/+/b", ErrInvalidTopicNameWildcard},
+		{"a/#", ErrInvalidTopicNameWildcard},
+		{"a+b", ErrInvalidTopicNameWildcard},
+		{"a\x00b", ErrInvalidTopicUTF8},
+		{"a\xffb", ErrInvalidTopicUTF8},
+		{"\xed\xa0\x80", ErrInvalidTopicUTF8}, // surrogate
+		{strings.Repeat("a", 65535), nil},
+		{strings.Repeat("a", 65536), ErrInvalidTopicTooLong},
+	}
+	for _, test := range tests {
+		if err := ValidateTopicName(test.topic); err != test.err {
+			t.Errorf("ValidateTopicName(%.20q) returned %v, expected %v", test.topic, err, test.err)
+		}
+	}
+}
+
+func Test_ValidateTopicFilter(t *testing.T) {
+	tests := []struct {
+		filter string
+		err    error
+	}{
+		{"a", nil},
+		{"#", nil},
+		{"+", nil},
+		{"+/+/#", nil},
+		{"a/+/b", nil},
+		{"/#", nil},
+		{"a//+", nil},
+		{"$share/group/a/+/#", nil},
+		{"$share/group//", nil},
+		{"$share/g/#", nil},
+		{"$queue/a/b", nil},
+		{"", ErrInvalidTopicEmptyString},
+		{"a/#/c", ErrInvalidTopicMultilevel},
+		{"#/a", ErrInvalidTopicMultilevel},
+		{"foo+/bar", ErrInvalidTopicWildcard},
+		{"a/#b", ErrInvalidTopicWildcard},
+		{"a/b#", ErrInvalidTopicWildcard},
+		{"++", ErrInvalidTopicWildcard},
+		{"a\x00", ErrInvalidTopicUTF8},
+		{"\xc3", ErrInvalidTopicUTF8},
+		{strings.Repeat("+/", 32767) + "#", nil},
+		{strings.Repeat("+/", 32768), ErrInvalidTopicTooLong},
+		{"$share/", ErrInvalidSharedSubscription},
+		{"$share/group", ErrInvalidSharedSubscription},
+		{"$share/group/", ErrInvalidSharedSubscription},
+		{"$share//a", ErrInvalidSharedSubscription},
+		{"$share/gr+up/a", ErrInvalidSharedSubscription},
+		{"$share/#/a", ErrInvalidSharedSubscription},
+		{"$share/group/a/#/b", ErrInvalidTopicMultilevel},
+		{"$share/group/a+", ErrInvalidTopicWildcard},
+	}
+	for _, test := range tests {
+		if err := ValidateTopicFilter(test.filter); err != test.err {
+			t.Errorf("ValidateTopicFilter(%.20q) returned %v, expected %v", test.filter, err, test.err)
+		}
+	}
+}
+
+func Test_InvalidTopicsRejected(t *testing.T) {
+	ops := NewClientOptions().AddBroker("tcp://127.0.0.1:1").SetWill("will/+", "gone", 0, false)
+	c := NewClient(ops)
+	if token := c.Connect(); token.Wait() && token.Error() != ErrInvalidTopicNameWildcard {
+		t.Fatalf("expected connect to fail with invalid will topic, got %v", token.Error())
+	}
+	if token := c.Publish("a/+/b", 0, false, "x"); token.Wait() && token.Error() != ErrInvalidTopicNameWildcard {
+		t.Fatalf("expected publish to fail with invalid topic, got %v", token.Error())
+	}
+	if token := c.Publish("a/b", 3, false, "x"); token.Wait() && token.Error() != ErrInvalidQos {
+		t.Fatalf("expected publish to fail with invalid qos, got %v", token.Error())
+	}
+
+	c.AddRoute("a/#b", func(Client, Message) {})
+	if routes := c.(*client).msgRouter.routes.Len(); routes != 0 {
+		t.Fatalf("expected route with invalid topic not to be added, have %d routes", routes)
+	}
+}
@@ -0,0 +1,157 @@
+package mqtt
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// newWebsocketTestServer starts a server that upgrades connections with upgrader and passes them to handle
+func newWebsocketTestServer(upgrader *websocket.Upgrader, handle func(ws *websocket.Conn)) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer ws.Close()
+		handle(ws)
+	}))
+}
+
+func Test_WebsocketOptions(t *testing.T) {
+	upgrader := &websocket.Upgrader{Subprotocols: []string{"mqttv3.1"}, EnableCompression: true}
+	received := make(chan string, 1)
+	srv := newWebsocketTestServer(upgrader, func(ws *websocket.Conn) {
+		_, p, err := ws.ReadMessage()
+		if err == nil {
+			received <- string(p)
+		}
+		ws.ReadMessage() // wait for the client to close
+	})
+	defer srv.Close()
+
+	hooked := false
+	var upgrade *http.Response
+	options := &WebsocketOptions{
+		Subprotocols:      []string{"mqttv3.1", "mqtt"},
+		EnableCompression: true,
+		CompressionLevel:  9,
+		DialerHook:        func(d *websocket.Dialer) { hooked = true },
+		UpgradeResponse:   func(resp *http.Response, err error) { upgrade = resp },
+	}
+	conn, err := newWebsocket(context.Background(), "ws"+strings.TrimPrefix(srv.URL, "http"), nil, time.Second, nil, options, nil)
+	if err != nil {
+		t.Fatalf("unable to connect: %s", err)
+	}
+	defer conn.Close()
+	ws := conn.(*websocketConnector)
+	if p := ws.Subprotocol(); p != "mqttv3.1" {
+		t.Fatalf("negotiated subprotocol %q", p)
+	}
+	if !hooked {
+		t.Fatalf("dialer hook not called")
+	}
+	if upgrade == nil || upgrade.StatusCode != http.StatusSwitchingProtocols ||
+		!strings.Contains(upgrade.Header.Get("Sec-Websocket-Extensions"), "permessage-deflate") {
+		t.Fatalf("unexpected upgrade response %+v", upgrade)
+	}
+	msg := strings.Repeat("compressible ", 100)
+	if _, err := conn.Write([]byte(msg)); err != nil {
+		t.Fatalf("write failed: %s", err)
+	}
+	select {
+	case p := <-received:
+		if p != msg {
+			t.Fatalf("received %q", p)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("message not received")
+	}
+}
+
+func Test_WebsocketUpgradeFailure(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Reason", "denied")
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer srv.Close()
+
+	var upgrade *http.Response
+	options := &WebsocketOptions{UpgradeResponse: func(resp *http.Response, err error) { upgrade = resp }}
+	_, err := newWebsocket(context.Background(), "ws"+strings.TrimPrefix(srv.URL, "http"), nil, time.Second, nil, options, nil)
+	var upgradeErr *WebsocketUpgradeError
+	if !errors.As(err, &upgradeErr) {
+		t.Fatalf("expected a WebsocketUpgradeError, got %v", err)
+	}
+	if upgradeErr.StatusCode != http.StatusForbidden || upgradeErr.Header.Get("X-Reason") != "denied" {
+		t.Fatalf("unexpected error %+v", upgradeErr)
+	}
+	if !errors.Is(err, websocket.ErrBadHandshake) {
+		t.Fatalf("error does not wrap ErrBadHandshake: %v", err)
+	}
+	if upgrade == nil || upgrade.StatusCode != http.StatusForbidden {
+		t.Fatalf("upgrade response not passed to callback: %+v", upgrade)
+	}
+}
+
+func Test_WebsocketPing(t *testing.T) {
+	// The server responds to pings (the default) so the connection should remain open
+	srv := newWebsocketTestServer(&websocket.Upgrader{}, func(ws *websocket.Conn) {
+		for {
+			if _, _, err := ws.ReadMessage(); err != nil {
+				return
+			}
+		}
+	})
+	defer srv.Close()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+	options := &WebsocketOptions{PingInterval: 20 * time.Millisecond}
+
+	conn, err := newWebsocket(context.Background(), url, nil, time.Second, nil, options, nil)
+	if err != nil {
+		t.Fatalf("unable to connect: %s", err)
+	}
+	readErr := make(chan error, 1)
+	go func() {
+		_, err := conn.Read(make([]byte, 10))
+		readErr <- err
+	}()
+	select {
+	case err := <-readErr:
+		t.Fatalf("connection closed: %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+	conn.Close()
+	<-readErr
+
+	// If pongs are not returned the connection should be closed
+	srv2 := newWebsocketTestServer(&websocket.Upgrader{}, func(ws *websocket.Conn) {
+		ws.SetPingHandler(func(string) error { return nil })
+		for {
+			if _, _, err := ws.ReadMessage(); err != nil {
+				return
+			}
+		}
+	})
+	defer srv2.Close()
+	conn, err = newWebsocket(context.Background(), "ws"+strings.TrimPrefix(srv2.URL, "http"), nil, time.Second, nil, options, nil)
+	if err != nil {
+		t.Fatalf("unable to connect: %s", err)
+	}
+	defer conn.Close()
+	go func() {
+		_, err := conn.Read(make([]byte, 10))
+		readErr <- err
+	}()
+	select {
+	case <-readErr:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("connection not closed when pongs were not received")
+	}
+}
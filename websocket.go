@@ -1,10 +1,13 @@
 package mqtt
 
 import (
+	"context"
 	"crypto/tls"
+	"fmt"
 	"io"
 	"net"
 	"net/http"
+	"net/url"
 	"sync"
 	"time"
 
@@ -15,10 +18,56 @@ import (
 type WebsocketOptions struct {
 	ReadBufferSize  int
 	WriteBufferSize int
+
+	// Subprotocols are offered to the server in order of preference (default "mqtt"; some brokers require
+	// "mqttv3.1" when using MQTT 3.1)
+	Subprotocols []string
+
+	// EnableCompression requests permessage-deflate compression; CompressionLevel (if not 0) sets the
+	// flate compression level used when writing (see compress/flate)
+	EnableCompression bool
+	CompressionLevel  int
+
+	// PingInterval (if not 0) is the interval at which WebSocket ping frames are sent. The connection is
+	// closed if a pong has not been received by the time the next ping is due. This is in addition to the
+	// MQTT keepalive and can be used to keep intermediaries (e.g. load balancers) from closing idle connections.
+	PingInterval time.Duration
+
+	// DialerHook (if set) is called with the dialer before each connection is opened, allowing any of its
+	// settings (e.g. NetDialContext or Jar) to be changed
+	DialerHook func(d *websocket.Dialer)
+
+	// UpgradeResponse (if set) is called with the server's response to the HTTP upgrade request. It is called
+	// whether or not the upgrade succeeded (resp is nil if no response was received). The body of a failed
+	// upgrade response may be read (it contains at most the first 1024 bytes).
+	UpgradeResponse func(resp *http.Response, err error)
+}
+
+// WebsocketUpgradeError is returned when the server responds to the HTTP upgrade request but the upgrade fails
+type WebsocketUpgradeError struct {
+	StatusCode int         // HTTP status code returned by the server
+	Header     http.Header // headers returned by the server
+	Err        error
+}
+
+func (e *WebsocketUpgradeError) Error() string {
+	return fmt.Sprintf("websocket upgrade failed (%d %s): %s", e.StatusCode, http.StatusText(e.StatusCode), e.Err)
+}
+
+// Unwrap returns the underlying error
+func (e *WebsocketUpgradeError) Unwrap() error {
+	return e.Err
 }
 
 // NewWebsocket returns a new websocket and returns a net.Conn compatible interface using the gorilla/websocket package
 func NewWebsocket(host string, tlsc *tls.Config, timeout time.Duration, requestHeader http.Header, options *WebsocketOptions) (net.Conn, error) {
+	return newWebsocket(context.Background(), host, tlsc, timeout, requestHeader, options, nil)
+}
+
+// newWebsocket opens a websocket connection; if dialer is not nil it is used to establish the underlying network
+// connection (otherwise any proxy specified in the environment is used). The environment is still used when dialer
+// only adds a PROXY protocol header (no proxy set in the options), see proxyProtocolProxy.
+func newWebsocket(ctx context.Context, host string, tlsc *tls.Config, timeout time.Duration, requestHeader http.Header, options *WebsocketOptions, dialer ContextDialer) (net.Conn, error) {
 	if timeout == 0 {
 		timeout = 10 * time.Second
 	}
@@ -28,28 +77,79 @@ func NewWebsocket(host string, tlsc *tls.Config, timeout time.Duration, requestH
 		options = &WebsocketOptions{}
 	}
 
-	dialer := &websocket.Dialer{
+	subprotocols := options.Subprotocols
+	if len(subprotocols) == 0 {
+		subprotocols = []string{"mqtt"}
+	}
+
+	// The handshake timeout covers reading the upgrade response as well as the TCP and TLS handshakes
+	wsDialer := &websocket.Dialer{
 		Proxy:             http.ProxyFromEnvironment,
 		HandshakeTimeout:  timeout,
-		EnableCompression: false,
+		EnableCompression: options.EnableCompression,
 		TLSClientConfig:   tlsc,
-		Subprotocols:      []string{"mqtt"},
+		Subprotocols:      subprotocols,
 		ReadBufferSize:    options.ReadBufferSize,
 		WriteBufferSize:   options.WriteBufferSize,
 	}
 
-	ws, _, err := dialer.Dial(host, requestHeader)
+	if dialer != nil {
+		wsDialer.NetDialContext = dialer.DialContext
+		if pp, ok := dialer.(*proxyProtocolDialer); ok && pp.environment {
+			wsDialer.Proxy = proxyProtocolProxy(http.ProxyFromEnvironment)
+		} else {
+			wsDialer.Proxy = nil // dialer connects via the proxy set in the options (if any)
+		}
+	}
+	if options.DialerHook != nil {
+		options.DialerHook(wsDialer)
+	}
+
+	ws, resp, err := wsDialer.DialContext(ctx, host, requestHeader)
+	if options.UpgradeResponse != nil {
+		options.UpgradeResponse(resp, err)
+	}
 
 	if err != nil {
+		if resp != nil {
+			return nil, &WebsocketUpgradeError{StatusCode: resp.StatusCode, Header: resp.Header, Err: err}
+		}
 		return nil, err
 	}
 
+	if options.EnableCompression {
+		ws.EnableWriteCompression(true)
+		if options.CompressionLevel != 0 {
+			if err = ws.SetCompressionLevel(options.CompressionLevel); err != nil {
+				ws.Close()
+				return nil, err
+			}
+		}
+	}
+
 	wrapper := &websocketConnector{
 		Conn: ws,
 	}
+	if options.PingInterval > 0 {
+		wrapper.startPing(options.PingInterval)
+	}
 	return wrapper, err
 }
 
+// proxyProtocolProxy returns the websocket Proxy function used when a PROXY protocol header is sent but no proxy has
+// been set in the options. The HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables (as interpreted by proxy)
+// are honoured except that connections to brokers that would go via an HTTP proxy fail; the header would otherwise
+// be received by the proxy rather than the broker.
+func proxyProtocolProxy(proxy func(*http.Request) (*url.URL, error)) func(*http.Request) (*url.URL, error) {
+	return func(req *http.Request) (*url.URL, error) {
+		u, err := proxy(req)
+		if err != nil || u == nil {
+			return u, err
+		}
+		return nil, fmt.Errorf("unable to send PROXY protocol header via HTTP proxy %s (exclude the broker with NO_PROXY or use ClientOptions.SetProxy)", u.Host)
+	}
+}
+
 // websocketConnector is a websocket wrapper so it satisfies the net.Conn interface so it is a
 // drop in replacement of the golang.org/x/net/websocket package.
 // Implementation guide taken from https://github.com/gorilla/websocket/issues/282
@@ -58,6 +158,65 @@ type websocketConnector struct {
 	r   io.Reader
 	rio sync.Mutex
 	wio sync.Mutex
+
+	pong     chan struct{} // receives when a pong is received (if pings are being sent)
+	stopPing chan struct{} // closed when the connection is closed
+	stopOnce sync.Once
+}
+
+// startPing sends a ping every interval and closes the connection if the pong is not received before the next
+// ping is due. Pongs are processed by Read so, as with the MQTT keepalive, the connection must be being read.
+func (c *websocketConnector) startPing(interval time.Duration) {
+	c.pong = make(chan struct{}, 1)
+	c.stopPing = make(chan struct{})
+	c.SetPongHandler(func(string) error {
+		select {
+		case c.pong <- struct{}{}:
+		default:
+		}
+		return nil
+	})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		awaitingPong := false
+		for {
+			select {
+			case <-c.stopPing:
+				return
+			case <-c.pong:
+				awaitingPong = false
+			case <-ticker.C:
+				if awaitingPong {
+					ERROR.Println(NET, "websocket pong not received; closing connection")
+					c.Close()
+					return
+				}
+				// WriteControl may be called concurrently with other write methods
+				if err := c.WriteControl(websocket.PingMessage, nil, time.Now().Add(interval)); err != nil {
+					DEBUG.Println(NET, "unable to send websocket ping:", err)
+					return
+				}
+				awaitingPong = true
+			}
+		}
+	}()
+}
+
+// Close closes the connection (stopping pings if they are being sent)
+func (c *websocketConnector) Close() error {
+	if c.stopPing != nil {
+		c.stopOnce.Do(func() { close(c.stopPing) })
+	}
+	return c.Conn.Close()
+}
+
+// TLSConnectionState returns the state of the underlying TLS connection (ok is false if TLS is not in use)
+func (c *websocketConnector) TLSConnectionState() (state tls.ConnectionState, ok bool) {
+	if tc, isTLS := c.UnderlyingConn().(*tls.Conn); isTLS {
+		return tc.ConnectionState(), true
+	}
+	return state, false
 }
 
 // SetDeadline sets both the read and write deadlines